@@ -2,14 +2,17 @@ package apollo
 
 import (
 	"context"
+	"fmt"
 	"github.com/2345tech/apollo-agent/common"
 	"github.com/2345tech/apollo-agent/util"
 	"github.com/2345tech/apolloclient"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const TmpFileSuffix = ".tmp"
@@ -22,18 +25,71 @@ type WorkerContract interface {
 	CloseChan()
 	GetData() *sync.Map
 	DeleteDataKey(key string)
-	IsAllInOne() bool
+	GetState() []NamespaceState
+	Refetch(wg *sync.WaitGroup, ctx context.Context)
+}
+
+// NamespaceState namespace最近一次拉取的状态
+type NamespaceState struct {
+	Namespace  string
+	ReleaseKey string
+	Keys       int
+	FetchedAt  time.Time
+	UpdatedAt  time.Time
+	LastError  string
 }
 
 type MetaConfig struct {
-	Address    string
-	Cluster    string
-	ClientIp   string
-	AppId      string
-	Secret     string
-	Namespaces []string
-	FileName   string
-	Syntax     string
+	Address      string
+	Cluster      string
+	ClientIp     string
+	AppId        string
+	Secret       string
+	Namespaces   []string
+	NSConfig     map[string]*common.Namespace
+	FileName     string
+	Syntax       string
+	PartialWrite string
+	StartupGrace time.Duration
+	Render       util.RenderOptions
+}
+
+// IsAllInOne namespace是否合并写入到FileName
+func (m *MetaConfig) IsAllInOne(namespace string) bool {
+	if nsConfig, ok := m.NSConfig[namespace]; ok {
+		return nsConfig.AllInOne
+	}
+	return false
+}
+
+// IsOptional namespace是否为可选，可选的namespace拉取失败时不影响启动完成及fetch的结果
+func (m *MetaConfig) IsOptional(namespace string) bool {
+	if nsConfig, ok := m.NSConfig[namespace]; ok {
+		return nsConfig.Optional
+	}
+	return false
+}
+
+// RequiredNamespaces 必须拉取成功的namespace
+func (m *MetaConfig) RequiredNamespaces() []string {
+	nss := make([]string, 0)
+	for _, ns := range m.Namespaces {
+		if !m.IsOptional(ns) {
+			nss = append(nss, ns)
+		}
+	}
+	return nss
+}
+
+// InOneNamespaces 需要合并写入到FileName的namespace
+func (m *MetaConfig) InOneNamespaces() []string {
+	nss := make([]string, 0)
+	for _, ns := range m.Namespaces {
+		if m.IsAllInOne(ns) {
+			nss = append(nss, ns)
+		}
+	}
+	return nss
 }
 
 type ConfigData map[string]map[string]string
@@ -41,13 +97,30 @@ type ConfigData map[string]map[string]string
 type Apollo struct {
 	runMode string
 	Worker  []WorkerContract
-	Wg      *sync.WaitGroup
+	group   *workerGroup
+}
+
+// workerGroup 一次PostHandle启动的worker共用的WaitGroup、就绪计数及blocking记录。
+// worker只使用启动时传入的workerGroup，drain超时后未退出的worker不会影响重新启动的worker
+type workerGroup struct {
+	wg       *sync.WaitGroup
+	ready    func()
+	pending  int32
+	blocking *sync.Map
+}
+
+func newWorkerGroup(ready func()) *workerGroup {
+	return &workerGroup{
+		wg:       new(sync.WaitGroup),
+		ready:    ready,
+		blocking: new(sync.Map),
+	}
 }
 
 func NewHandler() common.AgentHandler {
 	return &Apollo{
 		Worker: make([]WorkerContract, 0),
-		Wg:     new(sync.WaitGroup),
+		group:  newWorkerGroup(nil),
 	}
 }
 
@@ -61,71 +134,294 @@ func (a *Apollo) SetRunMode(mode string) {
 
 func (a *Apollo) PostHandle(param *common.HandlerParam, ctx context.Context) error {
 	a.setWorkers(param)
+	group := newWorkerGroup(param.Ready)
+	a.group = group
+	if a.runMode == common.ModeOnce {
+		return a.fetchOnce(ctx)
+	}
 
 	// Get Config Data from Apollo Config Service
 	for _, worker := range a.Worker {
-		worker.GetConfig(a.Wg, ctx)
+		meta := worker.GetMeta()
+		log.Printf("[INFO] [appId] %v server=%s cluster=%s ip=%s namespaces=%d\n",
+			meta.AppId, meta.Address, meta.Cluster, meta.ClientIp, len(meta.Namespaces))
+		worker.GetConfig(group.wg, ctx)
 	}
 
 	// Collect Config Data Write To File
+	atomic.StoreInt32(&group.pending, int32(len(a.Worker)))
 	for _, worker := range a.Worker {
-		a.Wg.Add(1)
-		go a.WriteData(worker, ctx)
+		group.wg.Add(1)
+		go group.WriteData(worker, ctx)
 	}
 
 	log.Println("[INFO] apollo.Apollo handler running")
 	return nil
 }
 
+// AfterCompletion 等待所有worker退出，ctx到期时放弃等待，未退出的worker不再回收，仍使用原来的workerGroup
 func (a *Apollo) AfterCompletion(ctx context.Context) error {
-	a.Wg.Wait()
-	for _, worker := range a.Worker {
-		worker.CloseChan()
+	wg := a.group.wg
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		for _, worker := range a.Worker {
+			worker.CloseChan()
+		}
+	case <-ctx.Done():
+		err = fmt.Errorf("[WARNING] apollo.Apollo handler drain timeout, some workers are still running")
 	}
 	a.Worker = make([]WorkerContract, 0)
+	a.group = newWorkerGroup(nil)
 	log.Println("[INFO] apollo.Apollo handler stopped")
+	return err
+}
+
+func (a *Apollo) Refetch(ctx context.Context) error {
+	for _, worker := range a.Worker {
+		worker.Refetch(a.group.wg, ctx)
+	}
+	log.Println("[INFO] apollo.Apollo handler refetch all namespaces")
 	return nil
 }
 
-func (a *Apollo) WriteData(worker WorkerContract, ctx context.Context) {
-	defer a.Wg.Done()
+func (a *Apollo) Dump(ctx context.Context) error {
+	log.Printf("[INFO] apollo.Apollo handler state: mode=%s workers=%d\n", a.runMode, len(a.Worker))
+	for _, worker := range a.Worker {
+		meta := worker.GetMeta()
+		blocking := ""
+		if v, ok := a.group.blocking.Load(meta); ok {
+			blocking = v.(string)
+		}
+		log.Printf("[INFO] [appId] %v server=%s cluster=%s ip=%s file=%s syntax=%s partialWrite=%s blocking=%q\n",
+			meta.AppId, meta.Address, meta.Cluster, meta.ClientIp, meta.FileName, meta.Syntax, meta.PartialWrite, blocking)
+		for _, state := range worker.GetState() {
+			nsConfig := meta.NSConfig[state.Namespace]
+			log.Printf("[INFO] [appId] %v [Namespace] %v mode=%s interval=%s allInOne=%v releaseKey=%q keys=%d "+
+				"fetchedAt=%s updatedAt=%s lastError=%q\n",
+				meta.AppId, state.Namespace, nsConfig.Mode, nsConfig.PollInterval, nsConfig.AllInOne, state.ReleaseKey,
+				state.Keys, formatTime(state.FetchedAt), formatTime(state.UpdatedAt), state.LastError)
+		}
+	}
+	return nil
+}
+
+// fetchOnce 所有namespace各拉取一次并写入文件，有必须的namespace未拉取到或写入失败时返回错误
+func (a *Apollo) fetchOnce(ctx context.Context) error {
+	for _, worker := range a.Worker {
+		worker.GetConfig(a.group.wg, ctx)
+	}
+	a.group.wg.Wait()
+
+	missing, unwritten := make([]string, 0), make([]string, 0)
+	for _, worker := range a.Worker {
+		meta := worker.GetMeta()
+		data := getSyncMapData(worker.GetData())
+		for _, ns := range meta.Namespaces {
+			if _, ok := data[ns]; ok {
+				continue
+			}
+			if meta.IsOptional(ns) {
+				log.Printf("[WARNING] [appId] %v [Namespace] %v optional namespace not fetched\n", meta.AppId, ns)
+			} else {
+				missing = append(missing, meta.AppId+"/"+ns)
+			}
+		}
+		nss, blocking := writeConfig(meta, worker, meta.PartialWrite == common.PartialWriteAfterGrace)
+		a.group.reportBlocking(worker, blocking, meta.PartialWrite == common.PartialWriteAfterGrace)
+		// 拉取到但写入失败（权限、创建或覆盖文件出错）的必须namespace同样视为失败
+		written := make(map[string]bool, len(nss))
+		for _, ns := range nss {
+			written[ns] = true
+		}
+		for _, ns := range meta.RequiredNamespaces() {
+			if _, ok := data[ns]; ok && !written[ns] {
+				unwritten = append(unwritten, meta.AppId+"/"+ns)
+			}
+		}
+	}
+	errs := make([]string, 0, 2)
+	if len(missing) > 0 {
+		errs = append(errs, "fetch namespaces failed: "+strings.Join(missing, ", "))
+	}
+	if len(unwritten) > 0 {
+		errs = append(errs, "write config files of namespaces failed: "+strings.Join(unwritten, ", "))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("[ERROR] %s", strings.Join(errs, "; "))
+	}
+	log.Println("[INFO] apollo.Apollo fetch all namespaces done")
+	return nil
+}
+
+// WriteData 有namespace更新时写入文件。partialWrite为afterGrace时，启动宽限期过后allInOne文件不再等待未拉取到的namespace
+func (g *workerGroup) WriteData(worker WorkerContract, ctx context.Context) {
+	defer g.wg.Done()
 	meta := worker.GetMeta()
+	required := meta.RequiredNamespaces()
+	written := make(map[string]bool)
+	ready, partial := false, false
+	var grace <-chan time.Time
+	if meta.PartialWrite == common.PartialWriteAfterGrace {
+		grace = time.After(meta.StartupGrace)
+	}
+
+	write := func() {
+		nss, blocking := writeConfig(meta, worker, partial)
+		for _, ns := range nss {
+			written[ns] = true
+		}
+		g.reportBlocking(worker, blocking, partial)
+		if ready {
+			return
+		}
+		if allWritten(required, written) {
+			ready = true
+			log.Printf("[INFO] [appId] %v all namespaces written\n", meta.AppId)
+			g.workerReady()
+		} else if partial && len(written) > 0 {
+			ready = true
+			log.Printf("[WARNING] [appId] %v ready with partial config after startup grace\n", meta.AppId)
+			g.workerReady()
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("[INFO] [appId] %v WriteData down...\n", meta.AppId)
 			return
+		case <-grace:
+			grace, partial = nil, true
+			g.blocking.Delete(meta)
+			log.Printf("[INFO] [appId] %v startup grace %s elapsed, write inOneFile with fetched namespaces\n",
+				meta.AppId, meta.StartupGrace)
+			write()
 		case <-worker.GetChan():
-			if worker.IsAllInOne() {
-				if len(meta.Namespaces) == getSyncMapLen(worker.GetData()) {
-					writeConfigInOneFile(meta, worker)
-				}
-			} else {
-				writeConfigOneByOne(meta, worker)
-			}
+			write()
+		}
+	}
+}
+
+// reportBlocking 阻塞inOneFile写入的namespace变化时输出日志，并记录下来供Dump输出
+func (g *workerGroup) reportBlocking(worker WorkerContract, blocking []string, partial bool) {
+	meta := worker.GetMeta()
+	current := strings.Join(blocking, ",")
+	if last, ok := g.blocking.Load(meta); (ok && last.(string) == current) || (!ok && current == "") {
+		return
+	}
+	g.blocking.Store(meta, current)
+	if current == "" {
+		log.Printf("[INFO] [appId] %v inOneFile %s is no longer blocked\n", meta.AppId, meta.FileName)
+		return
+	}
+
+	blocked := make(map[string]bool)
+	for _, ns := range blocking {
+		blocked[ns] = true
+	}
+	reasons := make([]string, 0, len(blocking))
+	for _, state := range worker.GetState() {
+		if !blocked[state.Namespace] {
+			continue
+		}
+		switch {
+		case state.LastError != "":
+			reasons = append(reasons, state.Namespace+" ("+state.LastError+")")
+		case state.FetchedAt.IsZero():
+			reasons = append(reasons, state.Namespace+" (not fetched yet)")
+		default:
+			reasons = append(reasons, state.Namespace+" (no release)")
+		}
+	}
+	if partial {
+		log.Printf("[WARNING] [appId] %v inOneFile %s written without namespaces: %s\n",
+			meta.AppId, meta.FileName, strings.Join(reasons, ", "))
+	} else {
+		log.Printf("[WARNING] [appId] %v inOneFile %s waiting for namespaces: %s\n",
+			meta.AppId, meta.FileName, strings.Join(reasons, ", "))
+	}
+}
+
+func allWritten(nss []string, written map[string]bool) bool {
+	for _, ns := range nss {
+		if !written[ns] {
+			return false
 		}
 	}
+	return true
+}
+
+// workerReady 应用的所有必须的namespace首次写入文件后（或启动宽限期过后部分写入后）调用，所有应用均完成时通知agent
+func (g *workerGroup) workerReady() {
+	if atomic.AddInt32(&g.pending, -1) == 0 && g.ready != nil {
+		g.ready()
+	}
 }
 
 func (a *Apollo) setWorkers(param *common.HandlerParam) {
 	for _, app := range param.Apps {
 		worker := a.newWorker(param, app)
+		namespaces := make([]string, 0, len(app.Namespaces))
+		nsConfig := make(map[string]*common.Namespace)
+		transforms := make(map[string]*util.KeyTransform)
+		for _, ns := range app.Namespaces {
+			namespaces = append(namespaces, ns.Name)
+			nsConfig[ns.Name] = ns
+			if ns.Transform != nil {
+				transforms[ns.Name] = ns.Transform
+			}
+		}
 		worker.SetMeta(&MetaConfig{
-			Address:    param.Address,
-			Cluster:    param.Cluster,
-			ClientIp:   param.ClientIp,
-			AppId:      app.AppId,
-			Secret:     app.Secret,
-			Namespaces: app.Namespaces,
-			FileName:   app.FileName,
-			Syntax:     app.Syntax,
+			Address:      appOr(app.Address, param.Address),
+			Cluster:      appOr(app.Cluster, param.Cluster),
+			ClientIp:     appOr(app.ClientIp, param.ClientIp),
+			AppId:        app.AppId,
+			Secret:       app.Secret,
+			Namespaces:   namespaces,
+			NSConfig:     nsConfig,
+			FileName:     app.FileName,
+			Syntax:       app.Syntax,
+			PartialWrite: app.PartialWrite,
+			StartupGrace: app.StartupGrace,
+			Render: util.RenderOptions{
+				Flat:             app.Flat,
+				Nested:           app.Nested,
+				Separator:        app.NestSeparator,
+				Conflict:         app.NestConflict,
+				FlattenSeparator: app.FlattenSeparator,
+				XMLRoot:          app.XMLRoot,
+				EnvDialect:       app.EnvDialect,
+				StrictINI:        app.IniStrict,
+				PHP: util.PHPEncoder{
+					Style:       app.PhpStyle,
+					Typed:       app.PhpTyped,
+					StrictTypes: app.PhpStrictTypes,
+				},
+				Template:   app.Template,
+				Transforms: transforms,
+			},
 		})
 		a.Worker = append(a.Worker, worker)
 	}
 }
 
 func (a *Apollo) newWorker(param *common.HandlerParam, app *common.App) WorkerContract {
-	return NewDefaultWorker(param.AllInOne, app.PollInterval, a.runMode)
+	return NewDefaultWorker(a.runMode)
+}
+
+// appOr 应用未单独配置时使用全局配置
+func appOr(appValue, globalValue string) string {
+	if appValue == "" {
+		return globalValue
+	}
+	return appValue
 }
 
 func getApolloClient(address string, ctx context.Context) (*apolloclient.Client, error) {
@@ -141,46 +437,130 @@ func getApolloClient(address string, ctx context.Context) (*apolloclient.Client,
 	return client, nil
 }
 
-func writeConfigInOneFile(meta *MetaConfig, worker WorkerContract) {
+// writeConfig 合并写入及独立写入namespace配置，返回写入成功的namespace，以及未拉取到而阻塞inOneFile的必须的namespace。
+// partial为false时inOneFile等待所有必须的namespace，为true时使用已拉取到的namespace写入；可选的namespace不阻塞写入
+func writeConfig(meta *MetaConfig, worker WorkerContract, partial bool) (written, blocking []string) {
+	written = writeConfigOneByOne(meta, worker)
+	inOne := meta.InOneNamespaces()
+	if len(inOne) == 0 {
+		return written, nil
+	}
+	data := getSyncMapData(worker.GetData())
+	present := make([]string, 0, len(inOne))
+	for _, ns := range inOne {
+		if _, ok := data[ns]; ok {
+			present = append(present, ns)
+		} else if !meta.IsOptional(ns) {
+			blocking = append(blocking, ns)
+		}
+	}
+	if len(present) == 0 || (len(blocking) > 0 && !partial) {
+		return written, blocking
+	}
+	if writeConfigInOneFile(meta, present, data, renderOptions(meta, worker)) {
+		written = append(written, present...)
+	}
+	return written, blocking
+}
+
+// renderOptions 应用的输出选项，加上模板中使用的应用信息及各namespace当前的releaseKey
+func renderOptions(meta *MetaConfig, worker WorkerContract) util.RenderOptions {
+	opts := meta.Render
+	opts.TemplateMeta = util.TemplateMeta{
+		AppId:       meta.AppId,
+		Cluster:     meta.Cluster,
+		ReleaseKeys: make(map[string]string),
+	}
+	for _, state := range worker.GetState() {
+		opts.TemplateMeta.ReleaseKeys[state.Namespace] = state.ReleaseKey
+	}
+	return opts
+}
+
+func writeConfigInOneFile(meta *MetaConfig, nss []string, data ConfigData, opts util.RenderOptions) bool {
 	tmpFile := meta.FileName + TmpFileSuffix
-	if err := util.MultiNSInOneFile(tmpFile, meta.Syntax, meta.Namespaces, getSyncMapData(worker.GetData()));
-		err != nil {
+	defer removeTmpFile(tmpFile)
+	err := util.CreateFile(tmpFile, 0, -1, -1)
+	if err == nil {
+		err = util.MultiNSInOneFile(tmpFile, meta.Syntax, nss, data, opts)
+	}
+	if err != nil {
 		log.Printf("[WARN] [appId] %v WriteData error : %v \n", meta.AppId, err.Error())
-	} else {
-		if covered, err := fileCompareAndCover(tmpFile, meta.FileName); err != nil {
-			log.Printf("[WARNING] copy tmp to config failed. ERR# %s \n", err.Error())
-		} else if covered {
-			log.Printf("[INFO] =========================NEW CONFIG SUCCESS===========================")
-			log.Printf("[INFO] get a new config file. %s", meta.FileName)
-		}
+		return false
+	}
+	if covered, err := fileCompareAndCover(tmpFile, meta.FileName, 0, -1, -1); err != nil {
+		log.Printf("[WARNING] copy tmp to config failed. ERR# %s \n", err.Error())
+		return false
+	} else if covered {
+		log.Printf("[INFO] =========================NEW CONFIG SUCCESS===========================")
+		log.Printf("[INFO] get a new config file. %s", meta.FileName)
 	}
+	return true
 }
 
-func writeConfigOneByOne(meta *MetaConfig, worker WorkerContract) {
+// writeConfigOneByOne 不需要合并的namespace写入独立文件，返回写入成功的namespace
+func writeConfigOneByOne(meta *MetaConfig, worker WorkerContract) []string {
+	written := make([]string, 0)
+	opts := renderOptions(meta, worker)
 	for ns, data := range getSyncMapData(worker.GetData()) {
-		oldFile := filepath.Dir(meta.FileName) + string(os.PathSeparator) + ns
-		tmpFile := oldFile + TmpFileSuffix
-		if err := util.SingleNSInOneFile(tmpFile, util.NSSyntax(ns), data); err != nil {
-			log.Printf("[WARN] [appId] %v [Namespace] %v WriteData error : %v \n", meta.AppId, ns, err.Error())
+		if meta.IsAllInOne(ns) {
 			continue
 		}
-		worker.DeleteDataKey(ns)
-		if covered, err := fileCompareAndCover(tmpFile, oldFile); err != nil {
-			log.Printf("[WARNING] copy tmp to config failed. ERR# %s \n", err.Error())
-		} else if covered {
-			log.Printf("[INFO] =========================NEW CONFIG SUCCESS===========================")
-			log.Printf("[INFO] get a new config file. %s", oldFile)
+		nsConfig := meta.NSConfig[ns]
+		opts.Template = nsConfig.Template
+		if writeNamespaceFile(meta, worker, ns, nsConfig, data, opts) {
+			written = append(written, ns)
 		}
 	}
+	return written
 }
 
-func fileCompareAndCover(tmpFile, oldFile string) (bool, error) {
+// writeNamespaceFile tmp文件与配置文件一样按fileMode、owner创建，写入后删除，避免以默认权限留下配置的副本
+func writeNamespaceFile(meta *MetaConfig, worker WorkerContract, ns string, nsConfig *common.Namespace,
+	data map[string]string, opts util.RenderOptions) bool {
+	oldFile := nsConfig.File
+	tmpFile := oldFile + TmpFileSuffix
+	defer removeTmpFile(tmpFile)
+	err := util.CreateFile(tmpFile, nsConfig.FileMode, nsConfig.Uid, nsConfig.Gid)
+	if err == nil {
+		err = util.SingleNSInOneFile(tmpFile, nsConfig.Syntax, ns, data, opts)
+	}
+	if err != nil {
+		log.Printf("[WARN] [appId] %v [Namespace] %v WriteData error : %v \n", meta.AppId, ns, err.Error())
+		return false
+	}
+	worker.DeleteDataKey(ns)
+	if covered, err := fileCompareAndCover(tmpFile, oldFile, nsConfig.FileMode, nsConfig.Uid, nsConfig.Gid); err != nil {
+		log.Printf("[WARNING] copy tmp to config failed. ERR# %s \n", err.Error())
+		return false
+	} else if covered {
+		log.Printf("[INFO] =========================NEW CONFIG SUCCESS===========================")
+		log.Printf("[INFO] get a new config file. %s", oldFile)
+	}
+	return true
+}
+
+// fileCompareAndCover 内容不同时覆盖配置文件。配置文件不存在时先按mode、owner创建，已存在时先设置mode、owner再写入，
+// 新内容不会以默认权限可读；mode为0、uid和gid为-1时保持配置文件原有的权限及属主
+func fileCompareAndCover(tmpFile, oldFile string, mode os.FileMode, uid, gid int) (bool, error) {
+	if _, err := os.Stat(oldFile); os.IsNotExist(err) {
+		if err = util.CreateFile(oldFile, mode, uid, gid); err != nil {
+			return false, err
+		}
+	} else if err = util.ApplyFileOwner(oldFile, mode, uid, gid); err != nil {
+		return false, fmt.Errorf("set file mode or owner of %s failed, %s", oldFile, err.Error())
+	}
 	md5Old, _ := util.HashFileMd5(oldFile)
 	md5Tmp, _ := util.HashFileMd5(tmpFile)
 	if md5Old != md5Tmp {
 		return true, util.CopyFile(tmpFile, oldFile)
-	} else {
-		return false, nil
+	}
+	return false, nil
+}
+
+func removeTmpFile(tmpFile string) {
+	if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARNING] remove tmp file %s failed. ERR# %s \n", tmpFile, err.Error())
 	}
 }
 
@@ -193,11 +573,9 @@ func getSyncMapData(syncMap *sync.Map) ConfigData {
 	return dataMap
 }
 
-func getSyncMapLen(syncMap *sync.Map) int {
-	length := 0
-	syncMap.Range(func(_, _ interface{}) bool {
-		length++
-		return true
-	})
-	return length
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
 }
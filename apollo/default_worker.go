@@ -11,27 +11,27 @@ import (
 const (
 	modePoll  = "poll"
 	modeWatch = "watch"
+	modeOnce  = "once"
 )
 
 type DefaultWorker struct {
-	mode     string
-	allInOne bool
-	interval time.Duration
-	update   chan struct{}
+	mode   string
+	update chan struct{}
 
-	Meta *MetaConfig
-	Data *sync.Map
+	Meta  *MetaConfig
+	Data  *sync.Map
+	State *sync.Map
 
 	client *apolloclient.Client
 }
 
-func NewDefaultWorker(allInOne bool, interval time.Duration, mode string) WorkerContract {
+// NewDefaultWorker mode为once时所有namespace只拉取一次，否则按各namespace配置的方式拉取
+func NewDefaultWorker(mode string) WorkerContract {
 	return &DefaultWorker{
-		mode:     mode,
-		allInOne: allInOne,
-		interval: interval,
-		update:   make(chan struct{}),
-		Data:     new(sync.Map),
+		mode:   mode,
+		update: make(chan struct{}),
+		Data:   new(sync.Map),
+		State:  new(sync.Map),
 	}
 }
 
@@ -56,11 +56,18 @@ func (w *DefaultWorker) GetConfig(wg *sync.WaitGroup, ctx context.Context) {
 			ClientIP:  w.Meta.ClientIp,
 		}
 
-		switch w.mode {
+		nsConfig := w.Meta.NSConfig[ns]
+		mode := nsConfig.Mode
+		if w.mode == modeOnce {
+			mode = modeOnce
+		}
+		switch mode {
 		case modePoll:
-			go w.polling(param, wg, ctx)
+			go w.polling(param, nsConfig.PollInterval, wg, ctx)
 		case modeWatch:
-			go w.watching(param, wg, ctx)
+			go w.watching(param, nsConfig.PollInterval, wg, ctx)
+		case modeOnce:
+			go w.fetching(param, wg, ctx)
 		}
 	}
 }
@@ -77,10 +84,6 @@ func (w *DefaultWorker) CloseChan() {
 	close(w.update)
 }
 
-func (w *DefaultWorker) IsAllInOne() bool {
-	return w.allInOne
-}
-
 func (w *DefaultWorker) GetData() *sync.Map {
 	return w.Data
 }
@@ -89,7 +92,94 @@ func (w *DefaultWorker) DeleteDataKey(key string) {
 	w.Data.Delete(key)
 }
 
-func (w *DefaultWorker) polling(param apolloclient.GetConfigParam, wg *sync.WaitGroup, ctx context.Context) {
+func (w *DefaultWorker) GetState() []NamespaceState {
+	states := make([]NamespaceState, 0, len(w.Meta.Namespaces))
+	for _, ns := range w.Meta.Namespaces {
+		state := NamespaceState{Namespace: ns}
+		if v, ok := w.State.Load(ns); ok {
+			state = v.(NamespaceState)
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Refetch 忽略releaseKey，立即重新拉取所有namespace
+func (w *DefaultWorker) Refetch(wg *sync.WaitGroup, ctx context.Context) {
+	if w.client == nil {
+		return
+	}
+	for _, ns := range w.Meta.Namespaces {
+		wg.Add(1)
+		param := apolloclient.GetConfigParam{
+			AppID:     w.Meta.AppId,
+			Cluster:   w.Meta.Cluster,
+			Namespace: ns,
+			Secret:    w.Meta.Secret,
+			ClientIP:  w.Meta.ClientIp,
+		}
+		go func() {
+			defer wg.Done()
+			log.Printf("[INFO] [appId] %v [Namespace] %v refetching...\n", param.AppID, param.Namespace)
+			w.fetch(&param, ctx)
+		}()
+	}
+}
+
+// fetch 拉取一次namespace配置，有新版本时（包括没有配置项的空namespace）通知写文件
+func (w *DefaultWorker) fetch(param *apolloclient.GetConfigParam, ctx context.Context) (apolloclient.ConfigData, error) {
+	data, err := w.client.GetConfig(param)
+	w.record(param.Namespace, data, err)
+	if err != nil {
+		log.Printf("[ERROR] [appId] %v [Namespace] %v GetConfig from Apollo Config Service error:%v\n",
+			param.AppID, param.Namespace, err.Error())
+		return data, err
+	}
+	if data.Configs != nil {
+		w.Data.Store(param.Namespace, data.Configs)
+		w.notify(ctx)
+	}
+	return data, nil
+}
+
+func (w *DefaultWorker) notify(ctx context.Context) {
+	if w.mode == modeOnce {
+		return
+	}
+	select {
+	case w.update <- struct{}{}:
+	case <-ctx.Done():
+	}
+}
+
+func (w *DefaultWorker) record(ns string, data apolloclient.ConfigData, err error) {
+	state := NamespaceState{Namespace: ns}
+	if v, ok := w.State.Load(ns); ok {
+		state = v.(NamespaceState)
+	}
+	state.FetchedAt = time.Now()
+	if err != nil {
+		state.LastError = err.Error()
+	} else {
+		state.LastError = ""
+		if data.Configs != nil {
+			state.ReleaseKey = data.ReleaseKey
+			state.Keys = len(data.Configs)
+			state.UpdatedAt = state.FetchedAt
+		}
+	}
+	w.State.Store(ns, state)
+}
+
+func sleep(d time.Duration, ctx context.Context) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
+
+func (w *DefaultWorker) polling(param apolloclient.GetConfigParam, interval time.Duration, wg *sync.WaitGroup,
+	ctx context.Context) {
 	defer wg.Done()
 	for {
 		select {
@@ -98,21 +188,20 @@ func (w *DefaultWorker) polling(param apolloclient.GetConfigParam, wg *sync.Wait
 			return
 		default:
 			log.Printf("[INFO] [appId] %v [Namespace] %v polling...\n", param.AppID, param.Namespace)
-			if data, err := w.client.GetConfig(&param); err == nil {
-				if len(data.Configs) > 0 {
-					w.Data.Store(param.Namespace, data.Configs)
-					w.update <- struct{}{}
-				}
-			} else {
-				log.Println("[ERROR] GetConfig from Apollo Config Service error:" + err.Error())
-			}
-			time.Sleep(w.interval)
+			_, _ = w.fetch(&param, ctx)
+			sleep(interval, ctx)
 		}
 	}
 }
 
+func (w *DefaultWorker) fetching(param apolloclient.GetConfigParam, wg *sync.WaitGroup, ctx context.Context) {
+	defer wg.Done()
+	log.Printf("[INFO] [appId] %v [Namespace] %v fetching...\n", param.AppID, param.Namespace)
+	_, _ = w.fetch(&param, ctx)
+}
 
-func (w *DefaultWorker) watching(param apolloclient.GetConfigParam, wg *sync.WaitGroup, ctx context.Context) {
+func (w *DefaultWorker) watching(param apolloclient.GetConfigParam, interval time.Duration, wg *sync.WaitGroup,
+	ctx context.Context) {
 	defer wg.Done()
 	notificationParam := &apolloclient.GetNotificationsParam{
 		AppID:         param.AppID,
@@ -134,24 +223,21 @@ func (w *DefaultWorker) watching(param apolloclient.GetConfigParam, wg *sync.Wai
 		default:
 			log.Printf("[INFO] [appId] %v [Namespace] %v watching...\n", param.AppID, param.Namespace)
 			if update, notifications, err := w.client.GetNotifications(notificationParam); err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
 				log.Printf("[ERROR] [appId] %v [Namespace] %v GetNotifications from Apollo Config Service error:%v\n",
 					param.AppID, param.Namespace, err.Error())
-				time.Sleep(w.interval)
+				sleep(interval, ctx)
 			} else {
 				if update && len(notifications) == 1 {
 					notificationParam.Notifications[0].NotificationID = notifications[0].NotificationID
-					if data, err := w.client.GetConfig(&param); err == nil {
-						if len(data.Configs) > 0 {
-							w.Data.Store(param.Namespace, data.Configs)
-							w.update <- struct{}{}
-						}
+					if data, err := w.fetch(&param, ctx); err == nil {
 						param.ReleaseKey = data.ReleaseKey
-					} else {
-						log.Println("[INFO] GetConfig from Apollo Config Service error:" + err.Error())
 					}
 				} else {
 					log.Printf("[WARNING] [appId] %v [Namespace] %v GetNotifications failed...\n", param.AppID, param.Namespace)
-					time.Sleep(w.interval)
+					sleep(interval, ctx)
 				}
 			}
 		}
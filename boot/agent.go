@@ -2,12 +2,15 @@ package boot
 
 import (
 	"context"
+	"fmt"
 	"github.com/2345tech/apollo-agent/common"
+	"github.com/2345tech/apollo-agent/util"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,6 +39,8 @@ func WithLauncher(launcher AgentLauncher) LauncherFunc {
 type SignalBus struct {
 	StopS    chan struct{}
 	RestartS chan struct{}
+	RefetchS chan struct{}
+	DumpS    chan struct{}
 }
 
 type Agent struct {
@@ -47,6 +52,7 @@ type Agent struct {
 	BeatFreQ   time.Duration
 
 	LogL    *LogLauncher
+	LockL   *LockLauncher
 	ConfigL *ProfileLauncher
 	SignalL *SignalLauncher
 
@@ -60,6 +66,8 @@ type Agent struct {
 	Handlers []common.AgentHandler
 
 	SigBus *SignalBus
+
+	pending int32
 }
 
 func New(lfs ...LauncherFunc) *Agent {
@@ -75,6 +83,8 @@ func New(lfs ...LauncherFunc) *Agent {
 		SigBus: &SignalBus{
 			StopS:    make(chan struct{}),
 			RestartS: make(chan struct{}),
+			RefetchS: make(chan struct{}),
+			DumpS:    make(chan struct{}),
 		},
 	}
 	args.Init(agent)
@@ -109,6 +119,10 @@ func (a *Agent) RegisterHandler(handlers ...common.AgentHandler) {
 }
 
 func (a *Agent) Start() error {
+	if a.Args.Command == CmdFetch {
+		return a.fetch()
+	}
+
 	if err := a.running(); err != nil {
 		return err
 	}
@@ -127,24 +141,71 @@ func (a *Agent) signalBusBooting(wg *sync.WaitGroup) {
 	if a.BeatFreQ == 0 {
 		a.BeatFreQ = 10 * time.Minute
 	}
+	beat := time.NewTicker(a.BeatFreQ)
+	defer beat.Stop()
+
+	// systemd开启WatchdogSec时，按超时时间的一半发送WATCHDOG=1，signalBus阻塞时systemd会重启agent
+	var watchdog <-chan time.Time
+	if interval := util.SdWatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+		log.Printf("[INFO] systemd watchdog enabled, interval %s\n", interval)
+	}
+
 	log.Println("[INFO] signalBus boot...")
 	for {
 		select {
 		case <-a.SigBus.StopS:
+			a.sdNotify("STOPPING=1", "STATUS=stopping")
 			a.Stop()
 			log.Println("[INFO] agent stopped")
 			return
 
 		case <-a.SigBus.RestartS:
+			a.sdNotify("RELOADING=1", "STATUS=reloading profile")
 			a.Restart()
 			log.Println("[INFO] agent restarted")
 
-		case <-time.After(a.BeatFreQ):
+		case <-a.SigBus.RefetchS:
+			a.eachHandler(func(handler common.AgentHandler) error {
+				return handler.Refetch(a.Context)
+			})
+
+		case <-a.SigBus.DumpS:
+			a.eachHandler(func(handler common.AgentHandler) error {
+				return handler.Dump(a.Context)
+			})
+
+		case <-watchdog:
+			a.sdNotify("WATCHDOG=1")
+
+		case <-beat.C:
 			log.Println("[INFO] agent heart beating")
 		}
 	}
 }
 
+// handlerReady 所有handler均完成首次写入后，通知systemd agent已就绪
+func (a *Agent) handlerReady() {
+	if atomic.AddInt32(&a.pending, -1) != 0 {
+		return
+	}
+	namespaces := 0
+	for _, app := range a.ConfigL.Profile.Apps {
+		namespaces += len(app.Namespaces)
+	}
+	log.Println("[INFO] agent ready, all config files written")
+	a.sdNotify("READY=1", fmt.Sprintf("STATUS=%d app(s), %d namespace(s) synced from %s",
+		len(a.ConfigL.Profile.Apps), namespaces, a.ConfigL.Profile.Server.Address))
+}
+
+func (a *Agent) sdNotify(states ...string) {
+	if _, err := util.SdNotify(states...); err != nil {
+		log.Println("[WARNING] systemd notify failed. error:" + err.Error())
+	}
+}
+
 func (a *Agent) running() error {
 	if a.isRunning {
 		return nil
@@ -168,7 +229,11 @@ func (a *Agent) running() error {
 	if a.ConfigL.Profile.Client.Type == common.ModeWatch {
 		runMode = common.ModeWatch
 	}
+	if a.Args.Command == CmdFetch {
+		runMode = common.ModeOnce
+	}
 
+	atomic.StoreInt32(&a.pending, int32(len(a.Handlers)))
 	for _, handler := range a.Handlers {
 		handler.SetRunMode(runMode)
 		if err := handler.PostHandle(handlerParam, a.Context); err != nil {
@@ -179,6 +244,14 @@ func (a *Agent) running() error {
 	return nil
 }
 
+// fetch 拉取一次所有namespace并写入文件后退出
+func (a *Agent) fetch() error {
+	err := a.running()
+	a.Stop()
+	a.ShutDown()
+	return err
+}
+
 func (a *Agent) Restart() {
 	if a.isRunning {
 		a.Stop()
@@ -198,16 +271,31 @@ func (a *Agent) Stop() {
 		p.Stop()
 	}
 	a.Cancel()
+
+	// handler在drainTimeout内未退出完成时不再等待
+	drainCtx, cancel := context.WithTimeout(context.Background(), a.ConfigL.Profile.Client.DrainTimeout)
+	defer cancel()
 	hLen := len(a.Handlers)
 	for i := hLen - 1; i >= 0; i-- {
 		handler := a.Handlers[i]
-		if err := handler.AfterCompletion(a.Context); err != nil {
+		if err := handler.AfterCompletion(drainCtx); err != nil {
 			log.Println(err.Error())
 		}
 	}
 	a.isRunning = false
 }
 
+func (a *Agent) eachHandler(f func(handler common.AgentHandler) error) {
+	if !a.isRunning {
+		return
+	}
+	for _, handler := range a.Handlers {
+		if err := f(handler); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
 func (a *Agent) ShutDown() {
 	for _, p := range a.Launchers {
 		p.Shutdown()
@@ -222,17 +310,52 @@ func (a *Agent) fillHandlerParam() *common.HandlerParam {
 		Address:  a.ConfigL.Profile.Server.Address,
 		Cluster:  a.ConfigL.Profile.Server.Cluster,
 		ClientIp: a.ConfigL.Profile.Client.Ip,
-		AllInOne: a.ConfigL.Profile.Client.AllInOne,
 		Apps:     make([]*common.App, 0),
+		Ready:    a.handlerReady,
 	}
 	for _, app := range a.ConfigL.Profile.Apps {
+		namespaces := make([]*common.Namespace, 0, len(app.Namespaces))
+		for _, ns := range app.Namespaces {
+			mode, uid, gid, _ := ns.perm()
+			transform, _ := ns.transform(app)
+			namespaces = append(namespaces, &common.Namespace{
+				Name:         ns.Name,
+				Mode:         ns.PollOrWatch,
+				PollInterval: ns.PollInterval,
+				AllInOne:     *ns.AllInOne,
+				File:         ns.File,
+				Syntax:       ns.Syntax,
+				FileMode:     mode,
+				Uid:          uid,
+				Gid:          gid,
+				Optional:     ns.Optional,
+				Template:     ns.tmpl,
+				Transform:    transform,
+			})
+		}
 		param.Apps = append(param.Apps, &common.App{
-			AppId:        app.AppId,
-			Namespaces:   app.Namespaces,
-			Secret:       app.Secret,
-			PollInterval: app.PollInterval,
-			FileName:     app.InOneFile,
-			Syntax:       app.Syntax,
+			AppId:            app.AppId,
+			Address:          app.Server,
+			Cluster:          app.Cluster,
+			ClientIp:         app.Ip,
+			Namespaces:       namespaces,
+			Secret:           app.Secret,
+			FileName:         app.InOneFile,
+			Syntax:           app.Syntax,
+			PartialWrite:     app.PartialWrite,
+			StartupGrace:     app.StartupGrace,
+			Flat:             app.Flat,
+			Nested:           app.Nested,
+			NestSeparator:    app.NestSeparator,
+			NestConflict:     app.NestConflict,
+			FlattenSeparator: app.FlattenSeparator,
+			XMLRoot:          app.XMLRoot,
+			EnvDialect:       app.EnvDialect,
+			IniStrict:        app.IniStrict,
+			PhpStyle:         app.PhpStyle,
+			PhpTyped:         app.PhpTyped,
+			PhpStrictTypes:   app.PhpStrictTypes,
+			Template:         app.tmpl,
 		})
 	}
 	return param
@@ -1,6 +1,8 @@
 package boot
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"github.com/2345tech/apollo-agent/common"
@@ -8,23 +10,53 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
 const (
 	_defaultLogfile    = "./logs/agent.log"
 	_defaultConfigFile = "./conf/app.yaml"
+	_stdinConfigFile   = "-"
 	_defaultPprof      = false
+	_defaultFetchLog   = "/dev/stderr"
+	_runtimeLockDir    = "/run/apollo-agent"
+	_accessWrite       = 0x2 // access(2)的W_OK
+)
+
+const (
+	CmdRun      = "run"
+	CmdFetch    = "fetch"
+	CmdConvert  = "convert"
+	CmdMigrate  = "migrate"
+	CmdValidate = "validate"
+	CmdStatus   = "status"
+	CmdVersion  = "version"
 )
 
 type Args struct {
 	agent      *Agent
+	Command    string
 	LogFile    *string
 	ConfigFile *string
 	Pprof      *bool
+	LockFile   *string
+	PidFile    *string
+	LockWait   *time.Duration
+	Override   *Override
+
+	configSet bool
+	helper    *helper
+}
 
-	helper *helper
+// Override 命令行或环境变量对配置文件中常用字段的覆盖，优先级：命令行 > 环境变量 > 配置文件
+type Override struct {
+	Address  string
+	Cluster  string
+	ClientIp string
+	Type     string
 }
 
 type helper struct {
@@ -34,36 +66,110 @@ type helper struct {
 }
 
 func NewArg() *Args {
+	disabled := _defaultPprof
+	lockFile, pidFile, lockWait := "", "", time.Duration(0)
 	return &Args{
-		helper: &helper{},
+		Pprof:    &disabled,
+		LockFile: &lockFile,
+		PidFile:  &pidFile,
+		LockWait: &lockWait,
+		Override: &Override{},
+		helper:   &helper{},
 	}
 }
 
 func (a *Args) Init(agent *Agent) {
 	a.agent = agent
-	if util.Str("APOLLO_AGENT_SERVER_ADDRESS", "") != "" {
-		agent.EnvProfile = true
-		stdOut := "/dev/stdout"
-		a.LogFile = &stdOut
-	} else {
-		a.LogFile = flag.String("l", _defaultLogfile, "log string: the log file name with absolute path")
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		a.legacy(args)
+		return
 	}
-	a.ConfigFile = flag.String("c", _defaultConfigFile, "config string: the config file name with absolute path")
-	a.Pprof = flag.Bool("p", _defaultPprof, "pprof bool: open pprof for debug, default http port is 18081")
 
-	a.helper.version = flag.Bool("V", false, "print version")
-	a.helper.author = flag.Bool("A", false, "print author")
-	a.helper.convertConfig = flag.Bool("convertConfig", false, "convert apolloAgentForPHP config to apollo-agent")
+	a.Command = args[0]
+	switch a.Command {
+	case CmdRun:
+		fs := a.newFlagSet(CmdRun, "run the agent in the foreground, keep config files in sync")
+		a.bindRuntime(fs, _defaultLogfile)
+		a.parse(fs, args[1:])
 
-	flag.Parse()
+	case CmdFetch:
+		fs := a.newFlagSet(CmdFetch, "fetch all namespaces once, write config files and exit")
+		a.bindRuntime(fs, _defaultFetchLog)
+		a.parse(fs, args[1:])
 
-	a.usage()
-}
+	case CmdValidate:
+		fs := a.newFlagSet(CmdValidate, "validate the profile and exit")
+		a.bindProfile(fs)
+		a.parse(fs, args[1:])
+		os.Exit(a.validate())
+
+	case CmdStatus:
+		fs := a.newFlagSet(CmdStatus, "print the apps, namespaces and config files described by the profile")
+		a.bindProfile(fs)
+		a.bindLock(fs)
+		a.parse(fs, args[1:])
+		os.Exit(a.status())
 
-func (a *Args) usage() {
-	if flag.NFlag() == 0 {
-		flag.PrintDefaults()
+	case CmdConvert:
+		fs := a.newFlagSet(CmdConvert, "convert apolloAgentForPHP config, or Apollo Java client app.properties "+
+			"(a file or a directory of services), to apollo-agent", "[oldConfigFile|app.properties|dir] [newConfigFile]")
+		java := &javaOptions{}
+		fs.StringVar(&java.ServerProperties, "server-properties", "",
+			"java: server.properties providing env and idc, default <dir>/server.properties or "+_javaServerProperties)
+		fs.StringVar(&java.EnvProperties, "env-properties", "",
+			"java: apollo-env.properties providing <env>.meta, default found next to app.properties")
+		fs.StringVar(&java.Env, "env", "", "java: override env of server.properties, e.g. DEV, PRO")
+		fs.StringVar(&java.InOneFile, "in-one-file", _javaDefaultInOneFile, "java: inOneFile of the imported apps")
+		a.parse(fs, args[1:])
+		a.convertConfig(fs.Args(), java)
 		os.Exit(0)
+
+	case CmdMigrate:
+		fs := a.newFlagSet(CmdMigrate, "upgrade the profile to the current version in place, keep a backup")
+		a.ConfigFile = fs.String("c", _defaultConfigFile,
+			"config string: the config file name with absolute path, - reads stdin and writes the result to stdout")
+		a.parse(fs, args[1:])
+		os.Exit(a.migrate())
+
+	case CmdVersion:
+		fs := a.newFlagSet(CmdVersion, "print version")
+		author := fs.Bool("author", false, "print author too")
+		a.parse(fs, args[1:])
+		fmt.Println(VERSION)
+		if *author {
+			fmt.Println(AUTHOR)
+		}
+		os.Exit(0)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", a.Command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// legacy 兼容旧版本不带子命令的参数（-c、-l、-p、-V、-A、-convertConfig），已废弃
+func (a *Args) legacy(args []string) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = usage
+	a.LogFile = fs.String("l", _defaultLogfile, "deprecated, use `run -l`")
+	a.ConfigFile = fs.String("c", _defaultConfigFile, "deprecated, use `run -c`")
+	a.Pprof = fs.Bool("p", _defaultPprof, "deprecated, use `run -p`")
+
+	a.helper.version = fs.Bool("V", false, "deprecated, use `version`")
+	a.helper.author = fs.Bool("A", false, "deprecated, use `version -author`")
+	a.helper.convertConfig = fs.Bool("convertConfig", false, "deprecated, use `convert`")
+
+	a.parse(fs, args)
+
+	if fs.NFlag() == 0 {
+		if !envProfileSet() {
+			usage()
+			os.Exit(0)
+		}
+		a.Command = CmdRun
+		return
 	}
 
 	if *a.helper.version {
@@ -77,22 +183,169 @@ func (a *Args) usage() {
 	}
 
 	if *a.helper.convertConfig {
-		a.convertConfig()
+		a.convertConfig(fs.Args(), &javaOptions{})
 		os.Exit(0)
 	}
+
+	fmt.Fprintln(os.Stderr, "[WARNING] flags without a command are deprecated, use `apollo-agent run` instead")
+	a.Command = CmdRun
+}
+
+func (a *Args) newFlagSet(name, summary string, positional ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags]%s\n\n%s\n\nFlags:\n",
+			filepath.Base(os.Args[0]), name, strings.Join(append([]string{""}, positional...), " "), summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (a *Args) bindProfile(fs *flag.FlagSet) {
+	a.ConfigFile = fs.String("c", _defaultConfigFile, "config string: the config file name with absolute path")
+	fs.StringVar(&a.Override.Address, "server", "",
+		"override server.address, env APOLLO_AGENT_SERVER_ADDRESS")
+	fs.StringVar(&a.Override.Cluster, "cluster", "",
+		"override server.cluster, env APOLLO_AGENT_SERVER_CLUSTER")
+	fs.StringVar(&a.Override.ClientIp, "ip", "",
+		"override client.ip, env APOLLO_AGENT_CLIENT_IP")
+	fs.StringVar(&a.Override.Type, "mode", "",
+		"override client.pollOrWatch (poll or watch), env APOLLO_AGENT_CLIENT_TYPE")
+}
+
+func (a *Args) bindLock(fs *flag.FlagSet) {
+	a.LockFile = fs.String("lock", "", "lock file preventing two agents with the same profile, "+
+		"default is "+_runtimeLockDir+"/apollo-agent-<hash>.lock, or in the temp dir when it is not writable")
+}
+
+func (a *Args) bindRuntime(fs *flag.FlagSet, logFile string) {
+	a.bindProfile(fs)
+	a.bindLock(fs)
+	a.LogFile = fs.String("l", logFile, "log string: the log file name with absolute path")
+	a.Pprof = fs.Bool("p", _defaultPprof, "pprof bool: open pprof for debug, default http port is 18081")
+	a.PidFile = fs.String("pidfile", "", "write the agent pid to this file")
+	a.LockWait = fs.Duration("wait", 0, "wait up to this long for the lock held by another agent, default refuse to start")
+}
+
+// LockFileName 单实例锁文件，未指定时为运行时目录下按启动配置命名的文件，见lockFileNames
+func (a *Args) LockFileName() string {
+	return a.lockFileNames()[0]
+}
+
+// lockFileNames 锁文件的候选路径：指定了 -lock 时只有该文件；未指定时 /run/apollo-agent 可写时在前，其次为临时目录。
+// 配置文件所在目录可能只读（如Kubernetes ConfigMap），因此锁文件不放在配置文件旁边
+func (a *Args) lockFileNames() []string {
+	if *a.LockFile != "" {
+		return []string{*a.LockFile}
+	}
+	base := "apollo-agent-" + a.profileHash() + ".lock"
+	runtimeFile := filepath.Join(_runtimeLockDir, base)
+	tempFile := filepath.Join(os.TempDir(), base)
+	if err := os.MkdirAll(_runtimeLockDir, 0755); err == nil && syscall.Access(_runtimeLockDir, _accessWrite) == nil {
+		return []string{runtimeFile, tempFile}
+	}
+	return []string{tempFile, runtimeFile}
+}
+
+// profileHash 配置文件按绝对路径，环境变量配置按服务地址、应用ID及inOneFile区分，使用不同配置的agent可以同时运行
+func (a *Args) profileHash() string {
+	key := ""
+	if a.agent.EnvProfile {
+		key = "env:" + envProfileKey()
+	} else if abs, err := filepath.Abs(*a.ConfigFile); err == nil {
+		key = "file:" + abs
+	} else {
+		key = "file:" + *a.ConfigFile
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+func envProfileKey() string {
+	names := []string{EnvProfileVar, "APOLLO_AGENT_SERVER_ADDRESS", "APOLLO_AGENT_SERVER_CLUSTER",
+		"APOLLO_AGENT_APP_ID", "APOLLO_AGENT_APP_IN_ONE_FILE"}
+	for i := 0; util.Str(fmt.Sprintf("APOLLO_AGENT_APPS_%d_ID", i), "") != ""; i++ {
+		names = append(names, fmt.Sprintf("APOLLO_AGENT_APPS_%d_ID", i), fmt.Sprintf("APOLLO_AGENT_APPS_%d_IN_ONE_FILE", i))
+	}
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, name+"="+util.Str(name, ""))
+	}
+	return strings.Join(values, "\n")
 }
 
-func (a *Args) convertConfig() {
+// parse 解析参数，并确定启动配置来源：显式指定了 -c 时使用配置文件，否则存在环境变量配置时使用环境变量
+func (a *Args) parse(fs *flag.FlagSet, args []string) {
+	_ = fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "c" {
+			a.configSet = true
+		}
+	})
+	if a.ConfigFile == nil {
+		return
+	}
+
+	if envProfileSet() && !a.configSet {
+		a.agent.EnvProfile = true
+		stdOut := "/dev/stdout"
+		a.LogFile = &stdOut
+		return
+	}
+
+	a.Override.Address = overrideVal(a.Override.Address, "APOLLO_AGENT_SERVER_ADDRESS")
+	a.Override.Cluster = overrideVal(a.Override.Cluster, "APOLLO_AGENT_SERVER_CLUSTER")
+	a.Override.ClientIp = overrideVal(a.Override.ClientIp, "APOLLO_AGENT_CLIENT_IP")
+	a.Override.Type = overrideVal(a.Override.Type, "APOLLO_AGENT_CLIENT_TYPE")
+}
+
+// envProfileSet 是否设置了环境变量启动配置
+func envProfileSet() bool {
+	return util.Str("APOLLO_AGENT_SERVER_ADDRESS", "") != "" || util.Str(EnvProfileVar, "") != ""
+}
+
+func overrideVal(flagVal, envName string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	return util.Str(envName, "")
+}
+
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, `Usage: %s <command> [flags]
+
+Commands:
+  run       run the agent in the foreground, keep config files in sync
+  fetch     fetch all namespaces once, write config files and exit
+  validate  validate the profile and exit
+  status    print the apps, namespaces and config files described by the profile
+  convert   convert apolloAgentForPHP or Apollo Java client config to apollo-agent
+  migrate   upgrade the profile to the current version in place, keep a backup
+  version   print version
+
+Run '%s <command> -h' for the flags of a command.
+The old flags (-c, -l, -p, -V, -A, -convertConfig) are deprecated but still accepted.
+`, name, name)
+}
+
+func (a *Args) convertConfig(args []string, java *javaOptions) {
 	oldConfigFile := "/opt/app/apolloAgentForPHP/conf/app.yaml"
 	newConfigFile := "/opt/app/apollo-agent/conf/app.yaml"
-	if len(flag.Args()) > 0 {
-		oldConfigFile = flag.Arg(0)
+	if len(args) > 0 {
+		oldConfigFile = args[0]
 	}
-	if len(flag.Args()) > 1 {
-		newConfigFile = flag.Arg(1)
+	if len(args) > 1 {
+		newConfigFile = args[1]
 	}
 
-	if convertOldConfigFileToNew(oldConfigFile, newConfigFile) {
+	convert := convertOldConfigFileToNew
+	if isJavaConfig(oldConfigFile) {
+		convert = func(oldFile, newFile string) bool {
+			return convertJavaConfigFile(oldFile, newFile, java)
+		}
+	}
+	if convert(oldConfigFile, newConfigFile) {
 		fmt.Println("===================CONVERT OLD CONFIG TO NEW SUCCESS===================")
 		return
 	}
@@ -128,6 +381,70 @@ func convertOldConfigFileToNew(oldFile, newFile string) bool {
 		return false
 	}
 
+	if !backupNewConfig(newFile) {
+		return false
+	}
+
+	oldConfigContent, err := ioutil.ReadFile(oldFile)
+	if err != nil {
+		fmt.Println("[ERROR] ReadFile old config file:" + oldFile + ", error:" + err.Error())
+		return false
+	}
+	tree := yaml.MapSlice{}
+	err = yaml.Unmarshal(oldConfigContent, &tree)
+	if err != nil {
+		fmt.Println("[ERROR] Unmarshal old config file:" + oldFile + ", error:" + err.Error())
+		return false
+	}
+
+	newConfigContent, warnings, err := migrateFrom(tree, 0)
+	if err != nil {
+		fmt.Println("[ERROR] Convert old config file error:" + err.Error())
+		return false
+	}
+	for _, warning := range warnings {
+		fmt.Println("[WARNING] " + warning)
+	}
+
+	if err := util.WriteFile(newFile, string(newConfigContent), 0644); err != nil {
+		fmt.Println("[ERROR] WriteFile new config file error:" + err.Error())
+		return false
+	}
+
+	return true
+}
+
+// convertJavaConfigFile 将Java客户端的app.properties（或目录下所有服务）转换为当前版本配置
+func convertJavaConfigFile(input, newFile string, java *javaOptions) bool {
+	profile, warnings, err := convertJavaConfig(input, java)
+	if err != nil {
+		fmt.Println("[ERROR] Convert java client config error:" + err.Error())
+		return false
+	}
+	for _, warning := range warnings {
+		fmt.Println("[WARNING] " + warning)
+	}
+	for _, app := range profile.Apps {
+		fmt.Printf("[INFO] import app %s, namespaces %s\n", app.AppId, strings.Join(namespaceNames(app), ","))
+	}
+
+	if !backupNewConfig(newFile) {
+		return false
+	}
+	newConfigContent, err := yaml.Marshal(profile)
+	if err != nil {
+		fmt.Println("[ERROR] Marshal new config file error:" + err.Error())
+		return false
+	}
+	if err := util.WriteFile(newFile, string(newConfigContent), 0644); err != nil {
+		fmt.Println("[ERROR] WriteFile new config file error:" + err.Error())
+		return false
+	}
+	return true
+}
+
+// backupNewConfig 转换的目标文件已存在时复制为 .example
+func backupNewConfig(newFile string) bool {
 	if _, err := os.Stat(newFile); os.IsNotExist(err) {
 		fmt.Println("[INFO] The new config NotExist will be create")
 	} else if os.IsPermission(err) {
@@ -140,18 +457,11 @@ func convertOldConfigFileToNew(oldFile, newFile string) bool {
 			return false
 		}
 	}
+	return true
+}
 
-	configOld := oldConfig{}
-	oldConfigContent, err := ioutil.ReadFile(oldFile)
-	if err != nil {
-		fmt.Println("[ERROR] ReadFile old config file:" + oldFile + ", error:" + err.Error())
-		return false
-	}
-	err = yaml.Unmarshal(oldConfigContent, &configOld)
-	if err != nil {
-		fmt.Println("[ERROR] Unmarshal old config file:" + oldFile + ", error:" + err.Error())
-		return false
-	}
+// convertOldConfig apolloAgentForPHP的配置转换为版本1的配置
+func convertOldConfig(configOld oldConfig) *Profile {
 	configNew := Profile{
 		Client: &Client{},
 		Server: &Server{},
@@ -193,7 +503,7 @@ func convertOldConfigFileToNew(oldFile, newFile string) bool {
 			}
 			appNew := App{
 				AppId:        appOld.AppId,
-				Namespaces:   namespaces,
+				Namespaces:   namespacesOf(namespaces),
 				Secret:       appOld.Secret,
 				Syntax:       syntax,
 				PollInterval: time.Duration(appOld.Interval) * time.Second,
@@ -202,17 +512,5 @@ func convertOldConfigFileToNew(oldFile, newFile string) bool {
 			configNew.Apps = append(configNew.Apps, &appNew)
 		}
 	}
-
-	newConfigContent, err := yaml.Marshal(configNew)
-	if err != nil {
-		fmt.Println("[ERROR] Marshal new config file error:" + err.Error())
-		return false
-	}
-
-	if err := util.WriteFile(newFile, string(newConfigContent), 0644); err != nil {
-		fmt.Println("[ERROR] WriteFile new config file error:" + err.Error())
-		return false
-	}
-
-	return true
+	return &configNew
 }
@@ -0,0 +1,158 @@
+package boot
+
+import (
+	"fmt"
+	"github.com/2345tech/apollo-agent/util"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+)
+
+func (a *Args) validate() int {
+	profile, err := a.loadProfile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("profile %s is valid, %d app(s)\n", a.profileSource(), len(profile.Apps))
+	return 0
+}
+
+func (a *Args) status() int {
+	profile, err := a.loadProfile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	running := false
+	for _, lockFile := range a.lockFileNames() {
+		if lockHeld(lockFile) {
+			fmt.Printf("agent:    running (pid %s, lock %s)\n", lockHolder(lockFile), lockFile)
+			running = true
+			break
+		}
+	}
+	if !running {
+		fmt.Println("agent:    not running")
+	}
+	fmt.Printf("profile:  %s\n", a.profileSource())
+	fmt.Printf("server:   %s\n", profile.Server.Address)
+	fmt.Printf("cluster:  %s\n", profile.Server.Cluster)
+	fmt.Printf("mode:     %s\n", profile.Client.Type)
+	fmt.Printf("allInOne: %v\n", profile.Client.AllInOne)
+	for _, app := range profile.Apps {
+		fmt.Printf("\napp %s (syntax %s, mode %s, pollInterval %s, allInOne %v, partialWrite %s, startupGrace %s)\n",
+			app.AppId, app.Syntax, app.PollOrWatch, app.PollInterval, *app.AllInOne, app.PartialWrite, app.StartupGrace)
+		fmt.Printf("  server %s, cluster %s, ip %s\n", app.Server, app.Cluster, strOr(app.Ip, "-"))
+		for _, ns := range app.Namespaces {
+			file, syntax := app.InOneFile, app.Syntax
+			if !*ns.AllInOne {
+				file, syntax = ns.File, ns.Syntax
+			}
+			optional := ""
+			if ns.Optional {
+				optional = ", optional"
+			}
+			fmt.Printf("  %s (%s, %s%s) -> [%s] %s\n",
+				ns.Name, ns.PollOrWatch, ns.PollInterval, optional, syntax, fileStatus(file))
+		}
+	}
+	return 0
+}
+
+// migrate 将配置文件升级到当前版本，原文件备份为 <config file>.v<version>.bak；-c - 时从stdin读取，升级后的配置输出到stdout
+func (a *Args) migrate() int {
+	name := *a.ConfigFile
+	if name == _stdinConfigFile {
+		return migrateStdin()
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] "+err.Error())
+		return 1
+	}
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] "+err.Error())
+		return 1
+	}
+	migrated, from, warnings, err := migrateProfile(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s: %s\n", name, err.Error())
+		return 1
+	}
+	if from == ProfileVersion {
+		fmt.Printf("profile %s is already version %d\n", name, ProfileVersion)
+		return 0
+	}
+	if err = yaml.Unmarshal(migrated, &Profile{}); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] migrated profile is invalid, %s\n", err.Error())
+		return 1
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", name, from)
+	if err = ioutil.WriteFile(backup, content, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] backup %s to %s failed, %s\n", name, backup, err.Error())
+		return 1
+	}
+	if err = util.WriteFile(name, string(migrated), info.Mode().Perm()); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] write %s failed, %s\n", name, err.Error())
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Println("[WARNING] " + warning)
+	}
+	fmt.Printf("profile %s migrated from version %d to %d, the original file (with comments) is kept in %s\n",
+		name, from, ProfileVersion, backup)
+	return 0
+}
+
+// migrateStdin 升级stdin中的配置（如APOLLO_AGENT_PROFILE的内容）并输出到stdout，告警输出到stderr
+func migrateStdin() int {
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[ERROR] "+err.Error())
+		return 1
+	}
+	migrated, _, warnings, err := migrateProfile(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] stdin: %s\n", err.Error())
+		return 1
+	}
+	if err = yaml.Unmarshal(migrated, &Profile{}); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] migrated profile is invalid, %s\n", err.Error())
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "[WARNING] "+warning)
+	}
+	fmt.Print(string(migrated))
+	return 0
+}
+
+func (a *Args) loadProfile() (*Profile, error) {
+	p := NewProfile()
+	p.agent = a.agent
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+	return p.Profile, nil
+}
+
+func (a *Args) profileSource() string {
+	if a.agent.EnvProfile {
+		return "ENV"
+	}
+	return *a.ConfigFile
+}
+
+func fileStatus(name string) string {
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return name + " (missing)"
+	} else if err != nil {
+		return name + " (" + err.Error() + ")"
+	}
+	return fmt.Sprintf("%s (%d bytes, updated %s)", name, info.Size(), info.ModTime().Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,241 @@
+package boot
+
+import (
+	"fmt"
+	"github.com/2345tech/apollo-agent/common"
+	"github.com/2345tech/apollo-agent/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	_javaAppProperties    = "app.properties"
+	_javaServerProperties = "/opt/settings/server.properties"
+	_javaEnvProperties    = "apollo-env.properties"
+	_javaDefaultNamespace = "application"
+	_javaDefaultInOneFile = "/opt/app/{appId}/.env"
+)
+
+// javaOptions Java客户端配置转换的参数
+type javaOptions struct {
+	ServerProperties string // server.properties，提供env、idc
+	EnvProperties    string // apollo-env.properties，提供<env>.meta
+	Env              string // 覆盖server.properties中的env
+	InOneFile        string // 生成的应用inOneFile，支持{appId}等占位符
+}
+
+// javaApp 从一个app.properties读取到的应用
+type javaApp struct {
+	appId      string
+	meta       string
+	cluster    string
+	secret     string
+	namespaces []string
+}
+
+// isJavaConfig 输入为目录或.properties文件时按Java客户端的配置转换
+func isJavaConfig(name string) bool {
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		return true
+	}
+	return strings.HasSuffix(name, ".properties")
+}
+
+// convertJavaConfig 读取Java客户端的app.properties（或目录下所有服务的app.properties）生成agent配置
+func convertJavaConfig(input string, opts *javaOptions) (*Profile, []string, error) {
+	files, err := findAppProperties(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no %s found in %s", _javaAppProperties, input)
+	}
+
+	root := input
+	if info, err := os.Stat(input); err == nil && !info.IsDir() {
+		root = filepath.Dir(input)
+	}
+	server, err := readServerProperties(root, opts.ServerProperties)
+	if err != nil {
+		return nil, nil, err
+	}
+	env := strings.ToLower(strOr(opts.Env, server["env"]))
+
+	warnings := make([]string, 0)
+	apps := make([]*javaApp, 0, len(files))
+	seen := make(map[string]string)
+	for _, file := range files {
+		app, msgs, err := readJavaApp(file, root, env, server, opts.EnvProperties)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, msgs...)
+		if app.appId == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: app.id is not set, skipped", file))
+			continue
+		}
+		if first, ok := seen[app.appId]; ok {
+			warnings = append(warnings, fmt.Sprintf("%s: app.id %s is already imported from %s, skipped",
+				file, app.appId, first))
+			continue
+		}
+		seen[app.appId] = file
+		apps = append(apps, app)
+	}
+	if len(apps) == 0 {
+		return nil, nil, fmt.Errorf("no app imported from %s", input)
+	}
+	return javaProfile(apps, opts), warnings, nil
+}
+
+// findAppProperties 输入为文件时直接使用，为目录时查找目录下所有的app.properties，跳过构建输出目录
+func findAppProperties(input string) ([]string, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{input}, nil
+	}
+	files := make([]string, 0)
+	err = filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			switch info.Name() {
+			case "target", "build", "out", "node_modules", ".git":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == _javaAppProperties {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// readServerProperties 未指定时依次查找输入目录下的server.properties及 /opt/settings/server.properties，都不存在时返回空
+func readServerProperties(root, name string) (map[string]string, error) {
+	if name != "" {
+		return readProperties(name)
+	}
+	for _, candidate := range []string{filepath.Join(root, "server.properties"), _javaServerProperties} {
+		if _, err := os.Stat(candidate); err == nil {
+			return readProperties(candidate)
+		}
+	}
+	return map[string]string{}, nil
+}
+
+// readJavaApp meta优先级：app.properties的apollo.meta > server.properties的apollo.meta > apollo-env.properties的<env>.meta；
+// cluster优先级：apollo.cluster > server.properties的idc > default
+func readJavaApp(file, root, env string, server map[string]string, envFile string) (*javaApp, []string, error) {
+	props, err := readProperties(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings := make([]string, 0)
+	app := &javaApp{
+		appId:   props["app.id"],
+		cluster: strOr(props["apollo.cluster"], server["idc"]),
+		secret:  props["apollo.accesskey.secret"],
+	}
+
+	app.meta = strOr(props["apollo.meta"], server["apollo.meta"])
+	if app.meta == "" && env != "" {
+		envMeta, err := readEnvProperties(file, root, envFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		app.meta = envMeta[env+".meta"]
+	}
+	if metas := splitList(app.meta); len(metas) > 1 {
+		warnings = append(warnings, fmt.Sprintf("%s: apollo.meta has %d addresses, only %s is used",
+			file, len(metas), metas[0]))
+		app.meta = metas[0]
+	}
+	if app.meta == "" {
+		warnings = append(warnings, fmt.Sprintf("%s: apollo.meta is not found, set server.address manually", file))
+	}
+
+	app.namespaces = splitList(props["apollo.bootstrap.namespaces"])
+	if len(app.namespaces) == 0 {
+		app.namespaces = []string{_javaDefaultNamespace}
+	}
+	return app, warnings, nil
+}
+
+// readEnvProperties apollo-env.properties在classpath中，依次查找app.properties所在目录、上级目录（META-INF的上级）及输入目录
+func readEnvProperties(file, root, name string) (map[string]string, error) {
+	if name != "" {
+		return readProperties(name)
+	}
+	dir := filepath.Dir(file)
+	for _, candidate := range []string{dir, filepath.Dir(dir), root} {
+		candidate = filepath.Join(candidate, _javaEnvProperties)
+		if _, err := os.Stat(candidate); err == nil {
+			return readProperties(candidate)
+		}
+	}
+	return map[string]string{}, nil
+}
+
+func readProperties(name string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return util.ParseProperties(string(content)), nil
+}
+
+// javaProfile 所有应用相同的meta、cluster作为全局配置，不同的作为应用单独的配置
+func javaProfile(apps []*javaApp, opts *javaOptions) *Profile {
+	profile := &Profile{
+		Version: ProfileVersion,
+		Client: &Client{
+			Type:     common.ModeWatch,
+			AllInOne: true,
+		},
+		Server: &Server{
+			Address: commonValue(apps, func(app *javaApp) string { return app.meta }),
+			Cluster: commonValue(apps, func(app *javaApp) string { return app.cluster }),
+		},
+		Apps: make([]*App, 0, len(apps)),
+	}
+	for _, app := range apps {
+		profile.Apps = append(profile.Apps, &App{
+			AppId:      app.appId,
+			Server:     uncommon(app.meta, profile.Server.Address),
+			Cluster:    uncommon(app.cluster, profile.Server.Cluster),
+			Namespaces: namespacesOf(app.namespaces),
+			Secret:     app.secret,
+			Syntax:     _defaultAppSyntax,
+			InOneFile:  strOr(opts.InOneFile, _javaDefaultInOneFile),
+		})
+	}
+	return profile
+}
+
+func commonValue(apps []*javaApp, value func(app *javaApp) string) string {
+	first := value(apps[0])
+	for _, app := range apps[1:] {
+		if value(app) != first {
+			return ""
+		}
+	}
+	return first
+}
+
+func uncommon(value, global string) string {
+	if value == global {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,115 @@
+package boot
+
+import (
+	"fmt"
+	"github.com/2345tech/apollo-agent/util"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LockLauncher 对锁文件加排他flock，防止使用同一配置的多个agent同时写入相同的文件
+type LockLauncher struct {
+	agent    *Agent
+	lockFile *os.File
+	pidFile  string
+}
+
+func NewLock() *LockLauncher {
+	return &LockLauncher{}
+}
+
+func (l *LockLauncher) Init(agent *Agent) error {
+	l.agent = agent
+	agent.LockL = l
+
+	name := agent.Args.LockFileName()
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, FilePerm)
+	if err != nil {
+		return fmt.Errorf("[ERROR] open lock file %s failed! err : %v", name, err)
+	}
+
+	deadline := time.Now().Add(*agent.Args.LockWait)
+	for waiting := false; ; waiting = true {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			_ = f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return fmt.Errorf("[ERROR] another agent (pid %s) is running with lock file %s", lockHolder(name), name)
+			}
+			return fmt.Errorf("[ERROR] lock file %s failed! err : %v", name, err)
+		}
+		if !waiting {
+			log.Printf("[INFO] lock file %s is held by pid %s, waiting...\n", name, lockHolder(name))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(pid+"\n"), 0)
+	}
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("[ERROR] write lock file %s failed! err : %v", name, err)
+	}
+	l.lockFile = f
+
+	if *agent.Args.PidFile != "" {
+		if err = util.WriteFile(*agent.Args.PidFile, pid+"\n", FilePerm); err != nil {
+			return fmt.Errorf("[ERROR] write pid file %s failed! err : %v", *agent.Args.PidFile, err)
+		}
+		l.pidFile = *agent.Args.PidFile
+	}
+	log.Printf("[INFO] lock file %s acquired, pid %s\n", name, pid)
+	return nil
+}
+
+func (l *LockLauncher) Run() error {
+	return nil
+}
+
+func (l *LockLauncher) Stop() {
+}
+
+func (l *LockLauncher) Shutdown() {
+	if l.pidFile != "" {
+		_ = os.Remove(l.pidFile)
+	}
+	if l.lockFile != nil {
+		_ = l.lockFile.Truncate(0)
+		_ = syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_UN)
+		_ = l.lockFile.Close()
+	}
+	log.Println("[INFO] LockLauncher stopped")
+}
+
+// lockHolder 读取锁文件中持有锁的agent进程号
+func lockHolder(name string) string {
+	content, err := ioutil.ReadFile(name)
+	if err != nil || strings.TrimSpace(string(content)) == "" {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// lockHeld 锁文件是否被其他进程持有
+func lockHeld(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		return err == syscall.EWOULDBLOCK
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
@@ -40,7 +40,7 @@ func (l *LogLauncher) Init(agent *Agent) error {
 
 func (l *LogLauncher) Run() error {
 	l.LogExpire = l.agent.LogExpire
-	if l.agent.EnvProfile {
+	if l.agent.EnvProfile || l.agent.Args.Command == CmdFetch {
 		l.booted = true
 	}
 	if l.booted {
@@ -55,9 +55,6 @@ func (l *LogLauncher) Stop() {
 }
 
 func (l *LogLauncher) Shutdown() {
-	if l.agent.EnvProfile {
-		l.stopLog <- true
-	}
 	close(l.stopLog)
 	l.booted = false
 	log.Println("[INFO] LogLauncher stopped")
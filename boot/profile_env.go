@@ -0,0 +1,142 @@
+package boot
+
+import (
+	"fmt"
+	"github.com/2345tech/apollo-agent/util"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var _intRegex = regexp.MustCompile(`^(0|-?[1-9][0-9]*)$`)
+
+// interpolateProfile 替换配置中字符串值里的${ENV_VAR}、${ENV_VAR:-default}，$${ 写为 ${，
+// 只替换值，环境变量中的特殊字符不会改变yaml结构；引用了未设置且没有默认值的环境变量时返回错误，
+// 避免如secret以空值运行
+func interpolateProfile(source string, content []byte) ([]byte, error) {
+	var tree interface{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, err
+	}
+	missing := make(map[string]bool)
+	tree = interpolateValue(tree, missing)
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("ENV Variables %s referenced in %s are not set, use ${NAME:-default} to allow it",
+			strings.Join(names, ","), source)
+	}
+	return yaml.Marshal(tree)
+}
+
+func interpolateValue(v interface{}, missing map[string]bool) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		for k, item := range value {
+			value[k] = interpolateValue(item, missing)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = interpolateValue(item, missing)
+		}
+	case string:
+		expanded, names := util.ExpandEnv(value)
+		for _, name := range names {
+			missing[name] = true
+		}
+		// 整个值只有一个环境变量引用时还原bool、int类型，如 allInOne: ${ALL_IN_ONE:-true}
+		if util.IsEnvRef(value) {
+			if expanded == "true" || expanded == "false" {
+				return expanded == "true"
+			}
+			if _intRegex.MatchString(expanded) {
+				if n, err := strconv.Atoi(expanded); err == nil {
+					return n
+				}
+			}
+		}
+		return expanded
+	}
+	return v
+}
+
+// resolveSecretFiles 从secretFile读取应用的访问密钥（如挂载的Kubernetes Secret），相对路径相对于baseDir，
+// 记录读取到的内容用于判断文件是否变更
+func (p *ProfileLauncher) resolveSecretFiles(profile *Profile, baseDir string) error {
+	p.secretFiles = make(map[string]string)
+	for i, app := range profile.Apps {
+		if app.SecretFile == "" {
+			continue
+		}
+		if app.Secret != "" {
+			return fmt.Errorf("[ERROR] apps[%d] secret and secretFile are both set", i)
+		}
+		name := app.SecretFile
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(baseDir, name)
+		}
+		p.secretFiles[name] = ""
+		secret, err := readSecretFile(name)
+		if err != nil {
+			return fmt.Errorf("[ERROR] ReadFile secretFile %s error, %s", name, err.Error())
+		}
+		app.Secret = secret
+		p.secretFiles[name] = secret
+	}
+	return nil
+}
+
+// resolveTemplates 读取并解析应用及namespace的template模板文件，相对路径相对于baseDir，
+// 从配置文件加载时模板文件变更后同样重新加载配置
+func (p *ProfileLauncher) resolveTemplates(profile *Profile, baseDir string) error {
+	for i, app := range profile.Apps {
+		tmpl, err := p.parseTemplate(baseDir, app.Template)
+		if err != nil {
+			return fmt.Errorf("[ERROR] apps[%d].template %s", i, err.Error())
+		}
+		app.tmpl = tmpl
+		for _, ns := range app.Namespaces {
+			if ns.tmpl, err = p.parseTemplate(baseDir, ns.Template); err != nil {
+				return fmt.Errorf("[ERROR] apps[%d].namespace %q template %s", i, ns.Name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (p *ProfileLauncher) parseTemplate(baseDir, name string) (*template.Template, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(baseDir, name)
+	}
+	if p.watchFiles != nil {
+		p.watchFiles[filepath.Clean(name)] = true
+	}
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile %s error, %s", name, err.Error())
+	}
+	tmpl, err := util.ParseTemplate(filepath.Base(name), string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s error, %s", name, err.Error())
+	}
+	return tmpl, nil
+}
+
+func readSecretFile(name string) (string, error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,42 @@
+package boot
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInterpolateProfile(t *testing.T) {
+	os.Setenv("APOLLO_AGENT_TEST_SECRET", "s3cret")
+	os.Setenv("APOLLO_AGENT_TEST_FLAG", "true")
+	os.Setenv("APOLLO_AGENT_TEST_NUM", "8")
+	defer func() {
+		os.Unsetenv("APOLLO_AGENT_TEST_SECRET")
+		os.Unsetenv("APOLLO_AGENT_TEST_FLAG")
+		os.Unsetenv("APOLLO_AGENT_TEST_NUM")
+	}()
+	cases := []struct {
+		name, content string
+		want          string
+		err           string
+	}{
+		{"set", "secret: ${APOLLO_AGENT_TEST_SECRET}", "secret: s3cret\n", ""},
+		{"typed", "a: ${APOLLO_AGENT_TEST_FLAG}\nb: ${APOLLO_AGENT_TEST_NUM}\nc: x${APOLLO_AGENT_TEST_NUM}",
+			"a: true\nb: 8\nc: x8\n", ""},
+		{"default", "a: ${APOLLO_AGENT_TEST_UNSET:-dev}\nb: ${APOLLO_AGENT_TEST_UNSET:-}", "a: dev\nb: \"\"\n", ""},
+		{"escaped", "a: $${appId}/x\nb: $${APOLLO_AGENT_TEST_SECRET}", "a: ${appId}/x\nb: ${APOLLO_AGENT_TEST_SECRET}\n", ""},
+		{"unset", "apps:\n- secret: ${APOLLO_AGENT_TEST_UNSET}\n  ip: ${APOLLO_AGENT_TEST_UNSET2}", "",
+			"APOLLO_AGENT_TEST_UNSET,APOLLO_AGENT_TEST_UNSET2 referenced in test.yaml are not set"},
+	}
+	for _, c := range cases {
+		got, err := interpolateProfile("test.yaml", []byte(c.content))
+		switch {
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: got error %v, want %q", c.name, err, c.err)
+		case c.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		case c.err == "" && string(got) != c.want:
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -2,12 +2,15 @@ package boot
 
 import (
 	"fmt"
+	"github.com/2345tech/apollo-agent/common"
 	"github.com/2345tech/apollo-agent/util"
 	"github.com/fsnotify/fsnotify"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -17,33 +20,56 @@ const (
 	_defaultClientType      = "poll"
 	_defaultClientAllInOne  = true
 	_defaultClientLogExpire = 7 * 24 * time.Hour
+	_defaultClientDrain     = 10 * time.Second
+	_defaultClientPartial   = common.PartialWriteNever
+	_defaultClientGrace     = 30 * time.Second
 	_defaultServerCluster   = "default"
 	_defaultAppNamespace    = "application.properties"
 	_defaultAppPollInterval = 20 * time.Second
 	_defaultAppSyntax       = util.F_ENV
 )
 
+const (
+	_profileReloadDelay = 500 * time.Millisecond
+
+	EnvProfileVar = "APOLLO_AGENT_PROFILE"
+)
+
 type ProfileLauncher struct {
 	booted        bool
 	agent         *Agent
 	watcher       *fsnotify.Watcher
+	watchFiles    map[string]bool
+	watchDirs     map[string]bool
+	secretFiles   map[string]string
 	FromEnvVar    bool
 	Profile       *Profile
 	ProfileUpdate bool
 }
 
 type Profile struct {
-	Client *Client `yaml:"client"`
-	Server *Server `yaml:"server"`
-	Apps   []*App  `yaml:"apps"`
+	Version int     `yaml:"version,omitempty"`
+	Client  *Client `yaml:"client"`
+	Server  *Server `yaml:"server"`
+	ConfDir string  `yaml:"confDir,omitempty"`
+	Apps    []*App  `yaml:"apps"`
+}
+
+// confDirProfile conf.d目录下的配置文件，每个文件只提供apps，未配置version时与主配置文件的版本相同
+type confDirProfile struct {
+	Version int    `yaml:"version,omitempty"`
+	Apps    []*App `yaml:"apps"`
 }
 
 type Client struct {
-	Type      string        `yaml:"pollOrWatch"`
-	AllInOne  bool          `yaml:"allInOne"`
-	LogExpire time.Duration `yaml:"logExpire"`
-	Ip        string        `yaml:"ip"`
-	BeatFreQ  time.Duration `yaml:"beatFreq"`
+	Type         string        `yaml:"pollOrWatch"`
+	AllInOne     bool          `yaml:"allInOne"`
+	LogExpire    time.Duration `yaml:"logExpire"`
+	Ip           string        `yaml:"ip"`
+	BeatFreQ     time.Duration `yaml:"beatFreq"`
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+	PartialWrite string        `yaml:"partialWrite,omitempty"`
+	StartupGrace time.Duration `yaml:"startupGrace,omitempty"`
 }
 
 type Server struct {
@@ -52,12 +78,37 @@ type Server struct {
 }
 
 type App struct {
-	AppId        string        `yaml:"appId"`
-	Namespaces   []string      `yaml:"namespace"`
-	Secret       string        `yaml:"secret"`
-	Syntax       string        `yaml:"syntax"`
-	PollInterval time.Duration `yaml:"pollInterval"`
-	InOneFile    string        `yaml:"inOneFile"`
+	AppId            string               `yaml:"appId"`
+	Server           string               `yaml:"server,omitempty"`
+	Cluster          string               `yaml:"cluster,omitempty"`
+	Ip               string               `yaml:"ip,omitempty"`
+	Namespaces       []*Namespace         `yaml:"namespace"`
+	Secret           string               `yaml:"secret"`
+	SecretFile       string               `yaml:"secretFile,omitempty"`
+	Syntax           string               `yaml:"syntax"`
+	PollOrWatch      string               `yaml:"pollOrWatch,omitempty"`
+	PollInterval     time.Duration        `yaml:"pollInterval"`
+	AllInOne         *bool                `yaml:"allInOne,omitempty"`
+	PartialWrite     string               `yaml:"partialWrite,omitempty"`
+	StartupGrace     time.Duration        `yaml:"startupGrace,omitempty"`
+	InOneFile        string               `yaml:"inOneFile"`
+	NamespaceFile    string               `yaml:"namespaceFile,omitempty"`
+	Flat             bool                 `yaml:"flat,omitempty"`
+	Nested           bool                 `yaml:"nested,omitempty"`
+	NestSeparator    string               `yaml:"nestSeparator,omitempty"`
+	NestConflict     string               `yaml:"nestConflict,omitempty"`
+	FlattenSeparator string               `yaml:"flattenSeparator,omitempty"`
+	XMLRoot          string               `yaml:"xmlRoot,omitempty"`
+	EnvDialect       string               `yaml:"envDialect,omitempty"`
+	IniStrict        bool                 `yaml:"iniStrict,omitempty"`
+	PhpStyle         string               `yaml:"phpStyle,omitempty"`
+	PhpTyped         bool                 `yaml:"phpTyped,omitempty"`
+	PhpStrictTypes   bool                 `yaml:"phpStrictTypes,omitempty"`
+	Template         string               `yaml:"template,omitempty"`
+	Transform        *util.TransformRules `yaml:"transform,omitempty"`
+
+	tmpl       *template.Template
+	fromEnvVar bool
 }
 
 func NewProfile() *ProfileLauncher {
@@ -81,22 +132,36 @@ func (p *ProfileLauncher) Init(agent *Agent) error {
 
 func (p *ProfileLauncher) Run() error {
 	var err error
+	// 配置解析失败时仍然监听配置文件，修正配置后可以重新启动
 	if err = p.Parse(); err != nil {
-		return fmt.Errorf("[ERROR] " + err.Error())
+		err = fmt.Errorf("[ERROR] " + err.Error())
 	}
-	if p.booted {
-		return nil
+	p.FromEnvVar = p.agent.EnvProfile
+	if p.booted || p.FromEnvVar || p.agent.Args.Command == CmdFetch || len(p.watchFiles) == 0 {
+		return err
 	}
+	parseErr := err
 	if p.watcher, err = fsnotify.NewWatcher(); err != nil {
 		return fmt.Errorf("[ERROR] " + err.Error())
 	}
 
-	go p.watchConfigFile()
-	if err = p.watcher.Add(*p.agent.Args.ConfigFile); err != nil {
-		return fmt.Errorf("[ERROR] " + err.Error())
+	watch := &profileWatch{
+		files:   p.watchFiles,
+		dirs:    p.watchDirs,
+		secrets: make(map[string]string),
+	}
+	for file, secret := range p.secretFiles {
+		watch.secrets[file] = secret
+	}
+	go p.watchConfigFile(watch)
+	// 监听目录而不是文件，编辑器或配置管理工具通过rename替换文件后，监听不会丢失
+	for dir := range watch.watchDirs() {
+		if err = p.watcher.Add(dir); err != nil {
+			return fmt.Errorf("[ERROR] watch %s failed, %s", dir, err.Error())
+		}
 	}
 	p.booted = true
-	return nil
+	return parseErr
 }
 
 func (p *ProfileLauncher) Stop() {
@@ -104,7 +169,9 @@ func (p *ProfileLauncher) Stop() {
 }
 
 func (p *ProfileLauncher) Shutdown() {
-	_ = p.watcher.Close()
+	if p.watcher != nil {
+		_ = p.watcher.Close()
+	}
 	p.booted = false
 	log.Println("[INFO] ProfileLauncher stopped")
 }
@@ -113,85 +180,383 @@ func (p *ProfileLauncher) Parse() error {
 	if !p.ProfileUpdate {
 		return nil
 	}
+	// 解析及校验都在局部变量上进行，全部通过后才替换当前配置，重新加载失败时保留正在使用的配置
+	var profile *Profile
+	var err error
+	baseDir := ""
 	if p.agent.EnvProfile {
-		if err := p.loadEnvVar(); err != nil {
+		if profile, err = p.loadEnvVar(); err != nil {
 			return err
 		}
 	} else {
-		if err := p.loadConfigFile(); err != nil {
+		if profile, err = p.loadConfigFile(); err != nil {
 			return err
 		}
+		mainFile, _ := filepath.Abs(*p.agent.Args.ConfigFile)
+		baseDir = filepath.Dir(mainFile)
+	}
+	if err = p.resolveSecretFiles(profile, baseDir); err != nil {
+		return err
+	}
+	if err = p.resolveTemplates(profile, baseDir); err != nil {
+		return err
+	}
+	profile.wrapper()
+	profile.override(p.agent.Args.Override)
+	profile.inherit()
+	if err = profile.validate(); err != nil {
+		return err
 	}
-	p.Profile.wrapper()
+	p.Profile = profile
 	p.ProfileUpdate = false
 	return nil
 }
 
-func (p *ProfileLauncher) loadEnvVar() error {
-	p.Profile.Client.Type = util.Str("APOLLO_AGENT_CLIENT_TYPE", _defaultClientType)
-	p.Profile.Client.AllInOne = util.Bool("APOLLO_AGENT_CLIENT_ALLINONE", true)
-	p.Profile.Client.LogExpire = util.Dur("APOLLO_AGENT_CLIENT_LOGEXPIRE", _defaultClientLogExpire)
-	p.Profile.Client.Ip = util.Str("APOLLO_AGENT_CLIENT_IP", "")
-	p.Profile.Client.BeatFreQ = util.Dur("APOLLO_AGENT_CLIENT_BEATFREQ", _defaultAppPollInterval)
+// loadEnvVar 从环境变量加载启动配置，APOLLO_AGENT_PROFILE可以包含完整的yaml/json配置，
+// 单独的环境变量优先级更高；应用来自APOLLO_AGENT_PROFILE、APOLLO_AGENT_APPS_<n>_*（n从0连续编号）及APOLLO_AGENT_APP_*
+func (p *ProfileLauncher) loadEnvVar() (*Profile, error) {
+	profile := &Profile{}
+	allInOne := _defaultClientAllInOne
+	if content := util.Str(EnvProfileVar, ""); content != "" {
+		configs, _, err := loadMigrated(EnvProfileVar, []byte(content))
+		if err == nil {
+			configs, err = interpolateProfile(EnvProfileVar, configs)
+		}
+		if err == nil {
+			err = yaml.Unmarshal(configs, profile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[ERROR] Unmarshal ENV Variable %s error, %s", EnvProfileVar, err.Error())
+		}
+		if profile.Client != nil {
+			allInOne = profile.Client.AllInOne
+		}
+	}
+	if profile.Client == nil {
+		profile.Client = &Client{}
+	}
+	if profile.Server == nil {
+		profile.Server = &Server{}
+	}
+
+	errs := make([]string, 0)
+	collectErr := func() {
+		if err := util.LastErr(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
 
-	p.Profile.Server.Address = util.Str("APOLLO_AGENT_SERVER_ADDRESS", "")
-	p.Profile.Server.Cluster = strings.ToLower(util.Str("APOLLO_AGENT_SERVER_CLUSTER", _defaultServerCluster))
+	client := profile.Client
+	client.Type = util.Str("APOLLO_AGENT_CLIENT_TYPE", strOr(client.Type, _defaultClientType))
+	client.AllInOne = util.Bool("APOLLO_AGENT_CLIENT_ALLINONE", allInOne)
+	collectErr()
+	client.LogExpire = util.Dur("APOLLO_AGENT_CLIENT_LOGEXPIRE", durOr(client.LogExpire, _defaultClientLogExpire))
+	collectErr()
+	client.Ip = util.Str("APOLLO_AGENT_CLIENT_IP", client.Ip)
+	client.BeatFreQ = util.Dur("APOLLO_AGENT_CLIENT_BEATFREQ", durOr(client.BeatFreQ, _defaultAppPollInterval))
+	collectErr()
+	client.DrainTimeout = util.Dur("APOLLO_AGENT_CLIENT_DRAIN_TIMEOUT", durOr(client.DrainTimeout, _defaultClientDrain))
+	collectErr()
+	client.PartialWrite = util.Str("APOLLO_AGENT_CLIENT_PARTIAL_WRITE", strOr(client.PartialWrite, _defaultClientPartial))
+	client.StartupGrace = util.Dur("APOLLO_AGENT_CLIENT_STARTUP_GRACE", durOr(client.StartupGrace, _defaultClientGrace))
+	collectErr()
 
-	p.Profile.Apps = []*App{
-		{
-			AppId:        util.Str("APOLLO_AGENT_APP_ID", ""),
-			Namespaces:   strings.Split(util.Str("APOLLO_AGENT_APP_NAMESPACES", ""), ","),
-			Secret:       util.Str("APOLLO_AGENT_APP_SECRET", ""),
-			Syntax:       util.Str("APOLLO_AGENT_APP_SYNTAX", _defaultAppSyntax),
-			PollInterval: util.Dur("APOLLO_AGENT_APP_POLL_INTERVAL", _defaultAppPollInterval),
-			InOneFile:    util.Str("APOLLO_AGENT_APP_IN_ONE_FILE", _defaultAppNamespace),
-		},
+	profile.Server.Address = util.Str("APOLLO_AGENT_SERVER_ADDRESS", profile.Server.Address)
+	profile.Server.Cluster = strings.ToLower(util.Str("APOLLO_AGENT_SERVER_CLUSTER",
+		strOr(profile.Server.Cluster, _defaultServerCluster)))
+
+	for i := 0; util.Str(fmt.Sprintf("APOLLO_AGENT_APPS_%d_ID", i), "") != ""; i++ {
+		profile.Apps = append(profile.Apps, envApp(fmt.Sprintf("APOLLO_AGENT_APPS_%d_", i), collectErr))
 	}
-	if util.Str("APOLLO_AGENT_APP_ID", "") == "" {
-		return fmt.Errorf("[ERROR] ENV Variable APOLLO_AGENT_APP_ID is null")
+	if util.Str("APOLLO_AGENT_APP_ID", "") != "" {
+		profile.Apps = append(profile.Apps, envApp("APOLLO_AGENT_APP_", collectErr))
 	}
-	log.Println("[INFO] load boot config from system ENV variables")
-	return nil
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("[ERROR] invalid ENV Variables: %s", strings.Join(errs, "; "))
+	}
+	if len(profile.Apps) == 0 {
+		return nil, fmt.Errorf("[ERROR] no app found in ENV Variables, set APOLLO_AGENT_APP_ID, APOLLO_AGENT_APPS_0_ID or apps in %s",
+			EnvProfileVar)
+	}
+	log.Printf("[INFO] load boot config from system ENV variables, %d app(s)\n", len(profile.Apps))
+	return profile, nil
 }
 
-func (p *ProfileLauncher) loadConfigFile() error {
+// envTransform 读取以prefix开头的transform环境变量，列表以逗号分隔，replace、rename写为 old=new,old2=new2，都未设置时返回nil
+func envTransform(prefix string) *util.TransformRules {
+	rules := &util.TransformRules{
+		Include:     splitList(util.Str(prefix+"INCLUDE", "")),
+		Exclude:     splitList(util.Str(prefix+"EXCLUDE", "")),
+		StripPrefix: util.Str(prefix+"STRIP_PREFIX", ""),
+		Replace:     splitPairs(util.Str(prefix+"REPLACE", "")),
+		Case:        util.Str(prefix+"CASE", ""),
+		AddPrefix:   util.Str(prefix+"ADD_PREFIX", ""),
+		Rename:      splitPairs(util.Str(prefix+"RENAME", "")),
+	}
+	if len(rules.Include) == 0 && len(rules.Exclude) == 0 && rules.StripPrefix == "" && rules.Replace == nil &&
+		rules.Case == "" && rules.AddPrefix == "" && rules.Rename == nil {
+		return nil
+	}
+	return rules
+}
+
+// splitPairs 解析 old=new,old2=new2，没有内容时返回nil
+func splitPairs(value string) map[string]string {
+	var pairs map[string]string
+	for _, item := range splitList(value) {
+		if pairs == nil {
+			pairs = make(map[string]string)
+		}
+		i := strings.Index(item, "=")
+		if i < 0 {
+			pairs[item] = ""
+			continue
+		}
+		pairs[item[:i]] = item[i+1:]
+	}
+	return pairs
+}
+
+// envApp 读取以prefix开头的应用环境变量，未设置的项由wrapper填充默认值；
+// util.LastErr只保留最后一个错误，每读取一个bool、duration类型的变量后都调用collectErr
+func envApp(prefix string, collectErr func()) *App {
+	app := &App{
+		AppId:            util.Str(prefix+"ID", ""),
+		Server:           util.Str(prefix+"SERVER", ""),
+		Cluster:          util.Str(prefix+"CLUSTER", ""),
+		Ip:               util.Str(prefix+"IP", ""),
+		Namespaces:       namespacesOf(splitList(util.Str(prefix+"NAMESPACES", ""))),
+		Secret:           util.Str(prefix+"SECRET", ""),
+		Syntax:           util.Str(prefix+"SYNTAX", ""),
+		PollOrWatch:      util.Str(prefix+"POLL_OR_WATCH", ""),
+		PartialWrite:     util.Str(prefix+"PARTIAL_WRITE", ""),
+		InOneFile:        util.Str(prefix+"IN_ONE_FILE", ""),
+		NamespaceFile:    util.Str(prefix+"NAMESPACE_FILE", ""),
+		NestSeparator:    util.Str(prefix+"NEST_SEPARATOR", ""),
+		NestConflict:     util.Str(prefix+"NEST_CONFLICT", ""),
+		FlattenSeparator: util.Str(prefix+"FLATTEN_SEPARATOR", ""),
+		XMLRoot:          util.Str(prefix+"XML_ROOT", ""),
+		EnvDialect:       util.Str(prefix+"ENV_DIALECT", ""),
+		PhpStyle:         util.Str(prefix+"PHP_STYLE", ""),
+		Template:         util.Str(prefix+"TEMPLATE", ""),
+		Transform:        envTransform(prefix + "TRANSFORM_"),
+		fromEnvVar:       true,
+	}
+	if util.Str(prefix+"ALL_IN_ONE", "") != "" {
+		app.AllInOne = boolPtr(util.Bool(prefix+"ALL_IN_ONE", _defaultClientAllInOne))
+		collectErr()
+	}
+	app.PollInterval = util.Dur(prefix+"POLL_INTERVAL", 0)
+	collectErr()
+	app.StartupGrace = util.Dur(prefix+"STARTUP_GRACE", 0)
+	collectErr()
+	app.Flat = util.Bool(prefix+"FLAT", false)
+	collectErr()
+	app.Nested = util.Bool(prefix+"NESTED", false)
+	collectErr()
+	app.IniStrict = util.Bool(prefix+"INI_STRICT", false)
+	collectErr()
+	app.PhpTyped = util.Bool(prefix+"PHP_TYPED", false)
+	collectErr()
+	app.PhpStrictTypes = util.Bool(prefix+"PHP_STRICT_TYPES", false)
+	collectErr()
+	return app
+}
+
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func strOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func durOr(value, def time.Duration) time.Duration {
+	if value == 0 {
+		return def
+	}
+	return value
+}
+
+func (p *ProfileLauncher) loadConfigFile() (*Profile, error) {
 	if _, err := os.Stat(*p.agent.Args.ConfigFile); os.IsNotExist(err) {
-		return err
+		return nil, err
 	}
 	configs, err := ioutil.ReadFile(*p.agent.Args.ConfigFile)
 	if err != nil {
-		return fmt.Errorf("[ERROR] ReadFile app config file(default is app.yaml) error, " + err.Error())
+		return nil, fmt.Errorf("[ERROR] ReadFile app config file(default is app.yaml) error, " + err.Error())
+	}
+	profile := &Profile{}
+	version := 0
+	if configs, version, err = loadMigrated(*p.agent.Args.ConfigFile, configs); err == nil {
+		configs, err = interpolateProfile(*p.agent.Args.ConfigFile, configs)
+	}
+	if err == nil {
+		err = yaml.Unmarshal(configs, profile)
 	}
-	err = yaml.Unmarshal(configs, &p.Profile)
 	if err != nil {
-		return fmt.Errorf("[ERROR] Unmarshal config file(default is app.yaml) error, " + err.Error())
+		return nil, fmt.Errorf("[ERROR] Unmarshal config file(default is app.yaml) error, " + err.Error())
 	}
-
 	log.Println("[INFO] load config from " + *p.agent.Args.ConfigFile)
+
+	mainFile, _ := filepath.Abs(*p.agent.Args.ConfigFile)
+	p.watchFiles = map[string]bool{mainFile: true}
+	p.watchDirs = make(map[string]bool)
+	if profile.ConfDir != "" {
+		confDir := profile.ConfDir
+		if !filepath.IsAbs(confDir) {
+			confDir = filepath.Join(filepath.Dir(mainFile), confDir)
+		}
+		if err = p.loadConfDir(profile, confDir, version); err != nil {
+			return nil, err
+		}
+		p.watchDirs[confDir] = true
+	}
+	return profile, nil
+}
+
+// loadConfDir 按文件名顺序加载conf.d目录下的yaml文件，将其中的apps合并到主配置
+func (p *ProfileLauncher) loadConfDir(profile *Profile, confDir string, version int) error {
+	files, err := ioutil.ReadDir(confDir)
+	if err != nil {
+		return fmt.Errorf("[ERROR] ReadDir confDir %s error, %s", confDir, err.Error())
+	}
+	for _, file := range files {
+		if file.IsDir() || !isConfDirFile(file.Name()) {
+			continue
+		}
+		name := filepath.Join(confDir, file.Name())
+		configs, err := ioutil.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("[ERROR] ReadFile conf.d file %s error, %s", name, err.Error())
+		}
+		fragment := &confDirProfile{}
+		if err = checkConfDirKeys(configs); err == nil {
+			configs, err = migrateConfDirFile(name, configs, version, profile.Client)
+		}
+		if err == nil {
+			configs, err = interpolateProfile(name, configs)
+		}
+		if err == nil {
+			// 严格解析，拼错的字段不会被静默忽略
+			err = yaml.UnmarshalStrict(configs, fragment)
+		}
+		if err != nil {
+			return fmt.Errorf("[ERROR] Unmarshal conf.d file %s error, %s", name, err.Error())
+		}
+		profile.Apps = append(profile.Apps, fragment.Apps...)
+		log.Printf("[INFO] load %d app(s) from %s\n", len(fragment.Apps), name)
+	}
+	return nil
+}
+
+// checkConfDirKeys conf.d文件只能配置version和apps，client、server、confDir只在主配置文件中生效
+func checkConfDirKeys(content []byte) error {
+	tree := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return err
+	}
+	for _, item := range tree {
+		if key, _ := item.Key.(string); key == "client" || key == "server" || key == "confDir" {
+			return fmt.Errorf("%s can only be set in the main profile, conf.d files only support version and apps", key)
+		}
+	}
 	return nil
 }
 
-func (p *ProfileLauncher) watchConfigFile() {
-	if !p.FromEnvVar {
-		for {
-			select {
-			case event, ok := <-p.watcher.Events:
-				if !ok {
-					return
-				}
-				log.Println("[INFO] event:", event)
-				if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Rename == fsnotify.Rename {
-					log.Println("[INFO] apolloConfig restart...")
-					p.ProfileUpdate = true
-					p.agent.SigBus.RestartS <- struct{}{}
-				}
+func isConfDirFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// profileWatch 需要监听的配置文件，重新加载配置时会创建新的集合，监听协程只使用启动时的这一份
+type profileWatch struct {
+	files   map[string]bool
+	dirs    map[string]bool
+	secrets map[string]string
+}
+
+func (w *profileWatch) watchDirs() map[string]bool {
+	dirs := make(map[string]bool)
+	for file := range w.files {
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range w.dirs {
+		dirs[dir] = true
+	}
+	for file := range w.secrets {
+		dirs[filepath.Dir(file)] = true
+	}
+	return dirs
+}
+
+// isProfileEvent 变更的文件是否为主配置文件、conf.d目录下的配置文件或内容发生变化的secretFile
+func (w *profileWatch) isProfileEvent(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	if w.files[name] {
+		return true
+	}
+	if w.dirs[filepath.Dir(name)] && isConfDirFile(filepath.Base(name)) {
+		return true
+	}
+	// Kubernetes Secret通过替换目录下的..data软链接更新，事件中的文件名与secretFile不同，因此比较文件内容
+	for file, secret := range w.secrets {
+		if filepath.Dir(file) != filepath.Dir(name) {
+			continue
+		}
+		if content, err := readSecretFile(file); err != nil || content != secret {
+			w.secrets[file] = content
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ProfileLauncher) watchConfigFile(watch *profileWatch) {
+	if p.FromEnvVar {
+		return
+	}
+	// 合并短时间内的多个事件（如编辑器先写临时文件再rename），只重启一次
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-p.watcher.Events:
+			if !ok {
+				return
+			}
+			if !watch.isProfileEvent(event) {
+				continue
+			}
+			log.Println("[INFO] event:", event)
+			reload = time.After(_profileReloadDelay)
+
+		case <-reload:
+			reload = nil
+			log.Println("[INFO] apolloConfig restart...")
+			p.ProfileUpdate = true
+			p.agent.SigBus.RestartS <- struct{}{}
 
-			case err, ok := <-p.watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Println("[WARNING] error:", err)
+		case err, ok := <-p.watcher.Errors:
+			if !ok {
+				return
 			}
+			log.Println("[WARNING] error:", err)
 		}
 	}
 }
@@ -204,11 +569,23 @@ func (p *Profile) wrapper() {
 		if p.Client.LogExpire == 0 {
 			p.Client.LogExpire = _defaultClientLogExpire
 		}
+		if p.Client.DrainTimeout == 0 {
+			p.Client.DrainTimeout = _defaultClientDrain
+		}
+		if p.Client.PartialWrite == "" {
+			p.Client.PartialWrite = _defaultClientPartial
+		}
+		if p.Client.StartupGrace == 0 {
+			p.Client.StartupGrace = _defaultClientGrace
+		}
 	} else {
 		p.Client = &Client{
-			Type:      _defaultClientType,
-			AllInOne:  _defaultClientAllInOne,
-			LogExpire: _defaultClientLogExpire,
+			Type:         _defaultClientType,
+			AllInOne:     _defaultClientAllInOne,
+			LogExpire:    _defaultClientLogExpire,
+			DrainTimeout: _defaultClientDrain,
+			PartialWrite: _defaultClientPartial,
+			StartupGrace: _defaultClientGrace,
 		}
 	}
 	if p.Server != nil {
@@ -223,7 +600,7 @@ func (p *Profile) wrapper() {
 	if len(p.Apps) > 0 {
 		for _, app := range p.Apps {
 			if len(app.Namespaces) == 0 {
-				app.Namespaces = []string{_defaultAppNamespace}
+				app.Namespaces = namespacesOf([]string{_defaultAppNamespace})
 			}
 			if app.PollInterval == 0 {
 				app.PollInterval = _defaultAppPollInterval
@@ -238,7 +615,7 @@ func (p *Profile) wrapper() {
 	} else {
 		p.Apps = []*App{
 			{
-				Namespaces:   []string{_defaultAppNamespace},
+				Namespaces:   namespacesOf([]string{_defaultAppNamespace}),
 				PollInterval: _defaultAppPollInterval,
 				Syntax:       _defaultAppSyntax,
 				InOneFile:    "." + string(os.PathSeparator) + _defaultAppNamespace,
@@ -246,3 +623,168 @@ func (p *Profile) wrapper() {
 		}
 	}
 }
+
+func (p *Profile) override(o *Override) {
+	if o == nil {
+		return
+	}
+	if o.Address != "" {
+		p.Server.Address = o.Address
+	}
+	if o.Cluster != "" {
+		p.Server.Cluster = o.Cluster
+	}
+	if o.ClientIp != "" {
+		p.Client.Ip = o.ClientIp
+	}
+	if o.Type != "" {
+		p.Client.Type = o.Type
+	}
+}
+
+// inherit 应用未单独配置server、cluster、ip、pollOrWatch、allInOne、partialWrite、startupGrace时使用全局配置，
+// namespace未单独配置pollOrWatch、pollInterval、allInOne时使用应用的配置，并确定独立文件的路径及格式，
+// inOneFile及独立文件路径中的占位符在这里替换
+func (p *Profile) inherit() {
+	for _, app := range p.Apps {
+		app.Server = strOr(app.Server, p.Server.Address)
+		app.Cluster = strOr(app.Cluster, p.Server.Cluster)
+		app.Ip = strOr(app.Ip, p.Client.Ip)
+		app.PollOrWatch = strOr(app.PollOrWatch, p.Client.Type)
+		if app.AllInOne == nil {
+			app.AllInOne = boolPtr(p.Client.AllInOne)
+		}
+		app.PartialWrite = strOr(app.PartialWrite, p.Client.PartialWrite)
+		app.StartupGrace = durOr(app.StartupGrace, p.Client.StartupGrace)
+		app.InOneFile = expandAppPath(app.InOneFile, app)
+		app.NamespaceFile = strOr(app.NamespaceFile, _defaultNamespaceFile)
+		app.NestSeparator = strOr(app.NestSeparator, util.DefaultNestSeparator)
+		app.NestConflict = strOr(app.NestConflict, util.NestConflictFlat)
+		app.XMLRoot = strOr(app.XMLRoot, util.DefaultXMLRoot)
+		app.EnvDialect = strOr(app.EnvDialect, util.EnvDialectPlain)
+		app.PhpStyle = strOr(app.PhpStyle, util.PHPStyleShort)
+		for _, ns := range app.Namespaces {
+			ns.PollOrWatch = strOr(ns.PollOrWatch, app.PollOrWatch)
+			ns.PollInterval = durOr(ns.PollInterval, app.PollInterval)
+			if ns.AllInOne == nil {
+				ns.AllInOne = boolPtr(*app.AllInOne)
+			}
+			if !*ns.AllInOne {
+				ns.resolve(app)
+			}
+		}
+	}
+}
+
+func validMode(mode string) bool {
+	return mode == common.ModePoll || mode == common.ModeWatch
+}
+
+func (p *Profile) validate() error {
+	errs := make([]string, 0)
+	if !validMode(p.Client.Type) {
+		errs = append(errs, fmt.Sprintf("client.pollOrWatch %q must be %s or %s",
+			p.Client.Type, common.ModePoll, common.ModeWatch))
+	}
+	for i, app := range p.Apps {
+		if app.AppId == "" {
+			errs = append(errs, fmt.Sprintf("apps[%d].appId is empty", i))
+		}
+		if app.Server == "" {
+			errs = append(errs, fmt.Sprintf("apps[%d].server is empty and server.address is not set", i))
+		}
+		if !util.SupportSyntax(app.Syntax) {
+			errs = append(errs, fmt.Sprintf("apps[%d].syntax %q is not supported", i, app.Syntax))
+		}
+		if app.PartialWrite != common.PartialWriteNever && app.PartialWrite != common.PartialWriteAfterGrace {
+			errs = append(errs, fmt.Sprintf("apps[%d].partialWrite %q must be %s or %s",
+				i, app.PartialWrite, common.PartialWriteNever, common.PartialWriteAfterGrace))
+		}
+		if app.NestConflict != util.NestConflictFlat && app.NestConflict != util.NestConflictError {
+			errs = append(errs, fmt.Sprintf("apps[%d].nestConflict %q must be %s or %s",
+				i, app.NestConflict, util.NestConflictFlat, util.NestConflictError))
+		}
+		if (app.Syntax == util.F_TEMPLATE) != (app.Template != "") {
+			errs = append(errs, fmt.Sprintf("apps[%d].template and syntax: %s must be set together", i, util.F_TEMPLATE))
+		}
+		if !util.SupportEnvDialect(app.EnvDialect) {
+			errs = append(errs, fmt.Sprintf("apps[%d].envDialect %q must be one of %s", i, app.EnvDialect,
+				strings.Join([]string{util.EnvDialectPlain, util.EnvDialectPosix, util.EnvDialectDocker,
+					util.EnvDialectSystemd, util.EnvDialectPHP}, ", ")))
+		}
+		if app.PhpStyle != util.PHPStyleShort && app.PhpStyle != util.PHPStyleVarExport {
+			errs = append(errs, fmt.Sprintf("apps[%d].phpStyle %q must be %s or %s",
+				i, app.PhpStyle, util.PHPStyleShort, util.PHPStyleVarExport))
+		}
+		if app.Transform != nil {
+			if _, err := util.NewKeyTransform(*app.Transform); err != nil {
+				errs = append(errs, fmt.Sprintf("apps[%d].transform %s", i, err.Error()))
+			}
+		}
+		if !util.ValidXMLName(app.XMLRoot) {
+			errs = append(errs, fmt.Sprintf("apps[%d].xmlRoot %q is not a valid xml element name", i, app.XMLRoot))
+		}
+		if !validMode(app.PollOrWatch) {
+			errs = append(errs, fmt.Sprintf("apps[%d].pollOrWatch %q must be %s or %s",
+				i, app.PollOrWatch, common.ModePoll, common.ModeWatch))
+		}
+		if ph := unknownPlaceholders(app.InOneFile); len(ph) > 0 {
+			errs = append(errs, fmt.Sprintf("apps[%d].inOneFile has unsupported placeholder %s, use %s or %s",
+				i, strings.Join(ph, ","), _placeholderAppId, _placeholderCluster))
+		}
+		names, bases := make(map[string]bool), make(map[string]string)
+		keyedByBase := util.KeyedByBase(app.Syntax, app.Flat)
+		for j, ns := range app.Namespaces {
+			if ns.Name == "" {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace[%d] name is empty", i, j))
+			} else if names[ns.Name] {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q is duplicated", i, ns.Name))
+			}
+			names[ns.Name] = true
+			if base := util.ParseNSName(ns.Name).Base; *ns.AllInOne && keyedByBase && bases[base] != "" {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q and %q are both named %q in %s inOneFile",
+					i, bases[base], ns.Name, base, app.Syntax))
+			} else if *ns.AllInOne {
+				bases[base] = ns.Name
+			}
+			if *ns.AllInOne && !util.CanMerge(app.Syntax, ns.Name) {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q of format %s cannot be merged into %s inOneFile",
+					i, ns.Name, util.ParseNSName(ns.Name).Format, app.Syntax))
+			}
+			if !validMode(ns.PollOrWatch) {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q pollOrWatch %q must be %s or %s",
+					i, ns.Name, ns.PollOrWatch, common.ModePoll, common.ModeWatch))
+			}
+			if *ns.AllInOne && (ns.File != "" || ns.Syntax != "" || ns.Template != "") {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q file, syntax and template require allInOne: false",
+					i, ns.Name))
+			} else if !*ns.AllInOne && !util.SupportSyntax(ns.Syntax) {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q syntax %q is not supported", i, ns.Name, ns.Syntax))
+			} else if !*ns.AllInOne && (ns.Syntax == util.F_TEMPLATE) != (ns.Template != "") {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q template and syntax: %s must be set together",
+					i, ns.Name, util.F_TEMPLATE))
+			}
+			if ph := unknownPlaceholders(ns.File); len(ph) > 0 {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q file has unknown placeholder %s",
+					i, ns.Name, strings.Join(ph, ",")))
+			}
+			if _, _, _, err := ns.perm(); err != nil {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q %s", i, ns.Name, err.Error()))
+			}
+			if ns.Transform == nil {
+				continue
+			}
+			if _, err := util.NewKeyTransform(*ns.Transform); err != nil {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q transform %s", i, ns.Name, err.Error()))
+			} else if !ns.isKeyValue(app) {
+				errs = append(errs, fmt.Sprintf("apps[%d].namespace %q transform only applies to properties namespaces "+
+					"and json, yaml namespaces flattened by flattenSeparator", i, ns.Name))
+			}
+		}
+	}
+	errs = append(errs, p.outputFileErrors()...)
+	if len(errs) > 0 {
+		return fmt.Errorf("[ERROR] invalid profile: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package boot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfDirKeys(t *testing.T) {
+	cases := []struct {
+		content, err string
+	}{
+		{"apps:\n- appId: a", ""},
+		{"version: 3\napps:\n- appId: a", ""},
+		{"", ""},
+		{"client:\n  allInOne: false\napps: []", "client can only be set in the main profile"},
+		{"server:\n  address: http://apollo\napps: []", "server can only be set in the main profile"},
+		{"confDir: other.d", "confDir can only be set in the main profile"},
+		{"apps: [", "yaml"},
+	}
+	for _, c := range cases {
+		err := checkConfDirKeys([]byte(c.content))
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", c.content, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%q: got error %v, want %q", c.content, err, c.err)
+		}
+	}
+}
+
+func TestLoadConfDir(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		apps  []string
+		err   string
+	}{
+		{"file name order", map[string]string{
+			"20-b.yml":     "version: 3\napps:\n- appId: b",
+			"10-a.yaml":    "apps:\n- appId: a1\n- appId: a2",
+			".hidden.yaml": "apps:\n- appId: hidden",
+			"readme.txt":   "apps:\n- appId: txt",
+		}, []string{"main", "a1", "a2", "b"}, ""},
+		{"misspelled field", map[string]string{"a.yaml": "apps:\n- appId: a\n  namespaces: [db]"},
+			nil, "field namespaces not found"},
+		{"unknown top level key", map[string]string{"a.yaml": "app:\n- appId: a"}, nil, "field app not found"},
+		{"client block", map[string]string{"a.yaml": "client:\n  pollOrWatch: watch\napps:\n- appId: a"},
+			nil, "client can only be set in the main profile"},
+		{"server block", map[string]string{"a.yaml": "server:\n  address: http://apollo\napps:\n- appId: a"},
+			nil, "server can only be set in the main profile"},
+	}
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "apollo-agent-conf.d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range c.files {
+			if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		profile := &Profile{Client: &Client{AllInOne: true}, Apps: []*App{{AppId: "main"}}}
+		err = NewProfile().loadConfDir(profile, dir, ProfileVersion)
+		_ = os.RemoveAll(dir)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got error %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		apps := make([]string, 0)
+		for _, app := range profile.Apps {
+			apps = append(apps, app.AppId)
+		}
+		if strings.Join(apps, ",") != strings.Join(c.apps, ",") {
+			t.Errorf("%s: got apps %v, want %v", c.name, apps, c.apps)
+		}
+	}
+}
+
+func TestLoadEnvVar(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		apps []string
+		errs []string
+	}{
+		{"single app", map[string]string{"APOLLO_AGENT_APP_ID": "a", "APOLLO_AGENT_APP_FLAT": "true"},
+			[]string{"a"}, nil},
+		{"numbered apps and profile", map[string]string{
+			EnvProfileVar:            "apps:\n- appId: p",
+			"APOLLO_AGENT_APPS_0_ID": "a0",
+			"APOLLO_AGENT_APPS_1_ID": "a1",
+			"APOLLO_AGENT_APPS_3_ID": "skipped",
+			"APOLLO_AGENT_APP_ID":    "a",
+		}, []string{"p", "a0", "a1", "a"}, nil},
+		{"every typed error", map[string]string{
+			"APOLLO_AGENT_CLIENT_LOGEXPIRE":     "7days",
+			"APOLLO_AGENT_CLIENT_ALLINONE":      "maybe",
+			"APOLLO_AGENT_APPS_0_ID":            "a0",
+			"APOLLO_AGENT_APPS_0_POLL_INTERVAL": "soon",
+			"APOLLO_AGENT_APPS_0_FLAT":          "yes please",
+			"APOLLO_AGENT_APP_ID":               "a",
+			"APOLLO_AGENT_APP_ALL_IN_ONE":       "nope",
+			"APOLLO_AGENT_APP_PHP_STRICT_TYPES": "on",
+		}, nil, []string{
+			"APOLLO_AGENT_CLIENT_ALLINONE", "APOLLO_AGENT_CLIENT_LOGEXPIRE", "APOLLO_AGENT_APPS_0_POLL_INTERVAL",
+			"APOLLO_AGENT_APPS_0_FLAT", "APOLLO_AGENT_APP_ALL_IN_ONE", "APOLLO_AGENT_APP_PHP_STRICT_TYPES",
+		}},
+		{"no app", map[string]string{"APOLLO_AGENT_SERVER_ADDRESS": "http://apollo"}, nil, []string{"no app found"}},
+		{"invalid profile", map[string]string{EnvProfileVar: "apps: ["}, nil, []string{"Unmarshal ENV Variable"}},
+	}
+	for _, c := range cases {
+		for name, value := range c.env {
+			os.Setenv(name, value)
+		}
+		profile, err := NewProfile().loadEnvVar()
+		for name := range c.env {
+			os.Unsetenv(name)
+		}
+		if c.errs != nil {
+			if err == nil {
+				t.Errorf("%s: want error containing %v", c.name, c.errs)
+				continue
+			}
+			for _, want := range c.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("%s: %q not found in error %v", c.name, want, err)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		apps := make([]string, 0)
+		for _, app := range profile.Apps {
+			apps = append(apps, app.AppId)
+		}
+		if strings.Join(apps, ",") != strings.Join(c.apps, ",") {
+			t.Errorf("%s: got apps %v, want %v", c.name, apps, c.apps)
+		}
+	}
+}
+
+func TestValidateDuplicateBase(t *testing.T) {
+	cases := []struct {
+		syntax string
+		flat   bool
+		err    bool
+	}{
+		{"env", false, false},
+		{"properties", false, false},
+		{"ini", false, true},
+		{"ini", true, true},
+		{"php", false, true},
+		{"php", true, false},
+		{"json", false, true},
+		{"json", true, false},
+		{"yaml", false, true},
+		{"toml", true, false},
+	}
+	for _, c := range cases {
+		content := "server:\n  address: http://apollo\napps:\n- appId: a\n  inOneFile: /tmp/a.out\n" +
+			"  syntax: " + c.syntax + "\n  namespace: [db, db.properties]"
+		if c.flat {
+			content += "\n  flat: true"
+		}
+		err := testProfile(t, content).validate()
+		if c.err && (err == nil || !strings.Contains(err.Error(), `are both named "db"`)) || !c.err && err != nil {
+			t.Errorf("%s flat=%v: got error %v, want duplicated base %v", c.syntax, c.flat, err, c.err)
+		}
+	}
+}
+
+func TestParseKeepsProfileOnReloadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apollo-agent-profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "app.yaml")
+	valid := "version: 3\nserver:\n  address: http://apollo\napps:\n- appId: a\n  inOneFile: " + filepath.Join(dir, "a.env")
+	if err = ioutil.WriteFile(name, []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := NewProfile()
+	p.agent = &Agent{Args: &Args{ConfigFile: &name}}
+	if err = p.Parse(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	loaded := p.Profile
+
+	cases := []struct {
+		name, content string
+	}{
+		{"invalid yaml", "apps: ["},
+		{"invalid profile", valid + "\n  pollOrWatch: push"},
+		{"unset env var", valid + "\n  secret: ${APOLLO_AGENT_TEST_UNSET}"},
+		{"missing secret file", valid + "\n  secretFile: " + filepath.Join(dir, "secret")},
+		{"conf.d error", valid + "\nconfDir: " + filepath.Join(dir, "missing.d")},
+	}
+	for _, c := range cases {
+		if err = ioutil.WriteFile(name, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p.ProfileUpdate = true
+		if err = p.Parse(); err == nil {
+			t.Errorf("%s: want error", c.name)
+		}
+		if p.Profile != loaded || !p.ProfileUpdate {
+			t.Errorf("%s: profile was replaced by a failed reload", c.name)
+		}
+	}
+
+	if err = ioutil.WriteFile(name, []byte(strings.Replace(valid, "appId: a", "appId: b", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if p.Profile == loaded || p.Profile.Apps[0].AppId != "b" || p.ProfileUpdate {
+		t.Errorf("profile was not replaced after a successful reload")
+	}
+}
@@ -0,0 +1,299 @@
+package boot
+
+import (
+	"fmt"
+	"github.com/2345tech/apollo-agent/util"
+	"gopkg.in/yaml.v2"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// ProfileVersion 当前的配置版本：
+// 0 apolloAgentForPHP的配置（type、configs）
+// 1 v4.2.1及之前的apollo-agent配置，没有version字段
+// 2 namespace支持对象写法，独立文件的格式不再按namespace后缀猜测
+// 3 properties格式的namespace独立文件默认写为.properties，不再写为dotEnv
+const ProfileVersion = 3
+
+// migration 将配置从from版本升级到from+1版本，返回升级过程中需要提示的内容
+type migration struct {
+	from    int
+	summary string
+	migrate func(tree yaml.MapSlice) (yaml.MapSlice, []string, error)
+}
+
+var migrations = []migration{
+	{0, "convert apolloAgentForPHP config", migrateV0},
+	{1, "set syntax of namespaces whose output format was guessed from the name", migrateV1},
+	{2, "keep dotEnv syntax of properties namespaces written to their own files", migrateV2},
+}
+
+// loadMigrated 配置版本低于ProfileVersion时在内存中升级，并提示使用migrate命令更新配置文件，返回升级后的内容及原版本
+func loadMigrated(source string, content []byte) ([]byte, int, error) {
+	migrated, from, warnings, err := migrateProfile(content)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %s", source, err.Error())
+	}
+	if from == ProfileVersion {
+		return content, from, nil
+	}
+	if source == EnvProfileVar {
+		log.Printf("[WARNING] %s is profile version %d, migrated to version %d in memory, update it to version %d, "+
+			"e.g. `echo \"$%s\" | apollo-agent migrate -c -` prints the migrated profile\n",
+			source, from, ProfileVersion, ProfileVersion, source)
+	} else {
+		log.Printf("[WARNING] %s is profile version %d, migrated to version %d in memory, "+
+			"run `apollo-agent migrate -c %s` to update it\n", source, from, ProfileVersion, source)
+	}
+	for _, warning := range warnings {
+		log.Printf("[WARNING] %s: %s\n", source, warning)
+	}
+	return migrated, from, nil
+}
+
+// migrateProfile 识别配置版本并升级到ProfileVersion，已是当前版本时原样返回
+func migrateProfile(content []byte) ([]byte, int, []string, error) {
+	tree := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, 0, nil, err
+	}
+	from, err := profileVersion(tree)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if from == ProfileVersion {
+		return content, from, nil, nil
+	}
+	migrated, warnings, err := migrateFrom(tree, from)
+	return migrated, from, warnings, err
+}
+
+// migrateFrom 从指定版本依次执行升级，并写入version字段
+func migrateFrom(tree yaml.MapSlice, from int) ([]byte, []string, error) {
+	warnings := make([]string, 0)
+	for _, m := range migrations[from:] {
+		var msgs []string
+		var err error
+		if tree, msgs, err = m.migrate(tree); err != nil {
+			return nil, nil, fmt.Errorf("migrate profile version %d (%s) error, %s", m.from, m.summary, err.Error())
+		}
+		warnings = append(warnings, msgs...)
+	}
+	tree = append(yaml.MapSlice{{Key: "version", Value: ProfileVersion}}, deleteKey(tree, "version")...)
+	migrated, err := yaml.Marshal(tree)
+	return migrated, warnings, err
+}
+
+func profileVersion(tree yaml.MapSlice) (int, error) {
+	if v, ok := mapValue(tree, "version"); ok {
+		version, isInt := v.(int)
+		if !isInt || version < 0 {
+			return 0, fmt.Errorf("version %v must be a number", v)
+		}
+		if version > ProfileVersion {
+			return 0, fmt.Errorf("profile version %d is newer than %d supported by this agent", version, ProfileVersion)
+		}
+		return version, nil
+	}
+	_, hasConfigs := mapValue(tree, "configs")
+	_, hasApps := mapValue(tree, "apps")
+	if hasConfigs && !hasApps {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// migrateConfDirFile conf.d文件低于ProfileVersion时按主配置文件的client.allInOne在内存中升级，
+// 未配置version时使用主配置文件升级前的版本
+func migrateConfDirFile(source string, content []byte, version int, client *Client) ([]byte, error) {
+	tree := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err.Error())
+	}
+	from := version
+	if v, ok := mapValue(tree, "version"); ok {
+		if from, ok = v.(int); !ok || from < 1 || from > ProfileVersion {
+			return nil, fmt.Errorf("%s: version %v must be a number between 1 and %d", source, v, ProfileVersion)
+		}
+	}
+	if from < 1 {
+		from = 1
+	}
+	if from == ProfileVersion {
+		return content, nil
+	}
+
+	migrating := yaml.MapSlice{}
+	if client != nil {
+		migrating = append(migrating, yaml.MapItem{Key: "client", Value: yaml.MapSlice{{Key: "allInOne", Value: client.AllInOne}}})
+	}
+	migrating = append(migrating, deleteKey(tree, "version")...)
+	migrated, warnings, err := migrateFrom(migrating, from)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err.Error())
+	}
+	log.Printf("[WARNING] %s is profile version %d, migrated to version %d in memory, "+
+		"update it and set version: %d\n", source, from, ProfileVersion, ProfileVersion)
+	for _, warning := range warnings {
+		log.Printf("[WARNING] %s: %s\n", source, warning)
+	}
+	// 升级时加入的client只用于判断allInOne，不属于conf.d文件
+	tree = yaml.MapSlice{}
+	if err = yaml.Unmarshal(migrated, &tree); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(deleteKey(tree, "client"))
+}
+
+// migrateV0 apolloAgentForPHP的配置转换为apollo-agent的配置
+func migrateV0(tree yaml.MapSlice) (yaml.MapSlice, []string, error) {
+	content, err := yaml.Marshal(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	configOld := oldConfig{}
+	if err = yaml.Unmarshal(content, &configOld); err != nil {
+		return nil, nil, err
+	}
+	if content, err = yaml.Marshal(convertOldConfig(configOld)); err != nil {
+		return nil, nil, err
+	}
+	migrated := yaml.MapSlice{}
+	err = yaml.Unmarshal(content, &migrated)
+	return migrated, nil, err
+}
+
+// migrateV1 版本1中非allInOne的namespace按名称后缀猜测独立文件的格式（如 config.php 写为php），
+// 版本2只按Apollo的namespace格式确定，这里为这类namespace显式加上syntax以保持输出不变
+func migrateV1(tree yaml.MapSlice) (yaml.MapSlice, []string, error) {
+	// 版本1只有client.allInOne，配置了client但没有allInOne时为false，没有配置client时为true
+	if client, ok := mapValue(tree, "client"); ok {
+		if v, ok := mapValue(client, "allInOne"); ok {
+			if allInOne, isBool := v.(bool); !isBool {
+				return tree, []string{fmt.Sprintf("client.allInOne %v is not true or false, "+
+					"check the syntax of namespaces like *.php and *.ini manually", v)}, nil
+			} else if allInOne {
+				return tree, nil, nil
+			}
+		}
+	} else {
+		return tree, nil, nil
+	}
+
+	warnings := make([]string, 0)
+	apps, _ := mapValue(tree, "apps")
+	appList, _ := apps.([]interface{})
+	for i, item := range appList {
+		app, _ := item.(yaml.MapSlice)
+		nss, _ := mapValue(app, "namespace")
+		nsList, _ := nss.([]interface{})
+		for j, ns := range nsList {
+			name, isString := ns.(string)
+			if !isString || !util.ParseNSName(name).IsProperties() {
+				continue
+			}
+			switch syntax := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), ".")); syntax {
+			case util.F_PHP, util.F_INI:
+				nsList[j] = yaml.MapSlice{{Key: "name", Value: name}, {Key: "syntax", Value: syntax}}
+				warnings = append(warnings, fmt.Sprintf("apps[%d].namespace %q syntax %s was guessed from the name, "+
+					"now set explicitly", i, name, syntax))
+			}
+		}
+	}
+	return tree, warnings, nil
+}
+
+// migrateV2 版本2中properties格式的namespace独立文件默认写为dotEnv，版本3默认写为.properties，
+// 这里为不合并写入且未配置syntax的properties namespace显式加上 syntax: env 以保持输出不变
+func migrateV2(tree yaml.MapSlice) (yaml.MapSlice, []string, error) {
+	// 没有配置client时默认合并，配置了client但没有allInOne时为false
+	clientAllInOne := _defaultClientAllInOne
+	if client, ok := mapValue(tree, "client"); ok {
+		clientAllInOne = false
+		if v, ok := mapValue(client, "allInOne"); ok {
+			allInOne, isBool := v.(bool)
+			if !isBool {
+				return tree, []string{fmt.Sprintf("client.allInOne %v is not true or false, "+
+					"set syntax: env on properties namespaces with allInOne: false manually to keep dotEnv files", v)}, nil
+			}
+			clientAllInOne = allInOne
+		}
+	}
+
+	warnings := make([]string, 0)
+	apps, _ := mapValue(tree, "apps")
+	appList, _ := apps.([]interface{})
+	for i, item := range appList {
+		app, _ := item.(yaml.MapSlice)
+		appAllInOne, known := boolValue(app, "allInOne", clientAllInOne)
+		if !known {
+			warnings = append(warnings, fmt.Sprintf("apps[%d].allInOne is not true or false, "+
+				"set syntax: env on its properties namespaces manually to keep dotEnv files", i))
+			continue
+		}
+		nss, _ := mapValue(app, "namespace")
+		nsList, _ := nss.([]interface{})
+		for j, ns := range nsList {
+			var name string
+			nsMap, isMap := ns.(yaml.MapSlice)
+			if isMap {
+				v, _ := mapValue(nsMap, "name")
+				name, _ = v.(string)
+			} else if name, _ = ns.(string); name == "" {
+				continue
+			}
+			if _, hasSyntax := mapValue(nsMap, "syntax"); hasSyntax || !util.ParseNSName(name).IsProperties() {
+				continue
+			}
+			allInOne, known := boolValue(nsMap, "allInOne", appAllInOne)
+			if !known {
+				warnings = append(warnings, fmt.Sprintf("apps[%d].namespace %q allInOne is not true or false, "+
+					"set syntax: env manually to keep its dotEnv file", i, name))
+				continue
+			}
+			if allInOne {
+				continue
+			}
+			if isMap {
+				nsList[j] = append(nsMap, yaml.MapItem{Key: "syntax", Value: util.F_ENV})
+			} else {
+				nsList[j] = yaml.MapSlice{{Key: "name", Value: name}, {Key: "syntax", Value: util.F_ENV}}
+			}
+			warnings = append(warnings, fmt.Sprintf("apps[%d].namespace %q is written to its own file as dotEnv, "+
+				"now set syntax: env explicitly", i, name))
+		}
+	}
+	return tree, warnings, nil
+}
+
+// boolValue 读取bool配置，未配置时返回def，不是bool（如 ${VAR} 引用）时返回false
+func boolValue(v interface{}, key string, def bool) (value bool, known bool) {
+	raw, ok := mapValue(v, key)
+	if !ok {
+		return def, true
+	}
+	value, known = raw.(bool)
+	return
+}
+
+func mapValue(v interface{}, key string) (interface{}, bool) {
+	ms, _ := v.(yaml.MapSlice)
+	for _, item := range ms {
+		if k, ok := item.Key.(string); ok && k == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+func deleteKey(ms yaml.MapSlice, key string) yaml.MapSlice {
+	kept := make(yaml.MapSlice, 0, len(ms))
+	for _, item := range ms {
+		if k, ok := item.Key.(string); ok && k == key {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
@@ -0,0 +1,118 @@
+package boot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMigrateProfile(t *testing.T) {
+	cases := []struct {
+		name, content string
+		from          int
+		// 升级后各namespace的syntax，未配置syntax时为空；nil时升级后仍包含${VAR}引用，不检查
+		syntax   map[string]string
+		warnings int
+	}{
+		{"v0 apolloAgentForPHP", "type: 1\nallInOne: 0\naddress: http://apollo\n" +
+			"configs:\n- appId: a\n  path: /tmp\n  filename: a.env\n  namespace: [application, db.php]",
+			0, map[string]string{"application.properties": "env", "db.php.properties": "env"}, 2},
+		{"v1 guessed php and ini", "client:\n  allInOne: false\napps:\n- appId: a\n  namespace: [config.php, db.ini, app.json]",
+			1, map[string]string{"config.php": "php", "db.ini": "ini", "app.json": ""}, 2},
+		{"v1 allInOne", "client:\n  allInOne: true\napps:\n- appId: a\n  namespace: [config.php]",
+			1, map[string]string{"config.php": ""}, 0},
+		{"v1 without client", "apps:\n- appId: a\n  namespace: [config.php]",
+			1, map[string]string{"config.php": ""}, 0},
+		{"v2 properties written to own file", "version: 2\nclient:\n  allInOne: true\napps:\n- appId: a\n  allInOne: false\n" +
+			"  namespace:\n  - application\n  - {name: db, allInOne: true}\n  - {name: redis, syntax: ini}\n  - {name: cache}\n  - app.yaml",
+			2, map[string]string{"application": "env", "db": "", "redis": "ini", "cache": "env", "app.yaml": ""}, 2},
+		{"v2 unknown allInOne", "version: 2\nclient:\n  allInOne: ${ALL_IN_ONE}\napps:\n- appId: a\n  namespace: [application]",
+			2, nil, 1},
+		{"current", "version: 3\napps:\n- appId: a\n  namespace: [application]",
+			3, map[string]string{"application": ""}, 0},
+	}
+	for _, c := range cases {
+		migrated, from, warnings, err := migrateProfile([]byte(c.content))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if from != c.from || len(warnings) != c.warnings {
+			t.Errorf("%s: got version %d, warnings %q, want version %d, %d warnings", c.name, from, warnings, c.from, c.warnings)
+		}
+		if c.syntax == nil {
+			continue
+		}
+		profile := &Profile{}
+		if err = yaml.Unmarshal(migrated, profile); err != nil {
+			t.Errorf("%s: migrated profile is invalid, %v", c.name, err)
+			continue
+		}
+		if from != ProfileVersion && profile.Version != ProfileVersion {
+			t.Errorf("%s: got version field %d, want %d", c.name, profile.Version, ProfileVersion)
+		}
+		syntax := make(map[string]string)
+		for _, app := range profile.Apps {
+			for _, ns := range app.Namespaces {
+				syntax[ns.Name] = ns.Syntax
+			}
+		}
+		if !reflect.DeepEqual(syntax, c.syntax) {
+			t.Errorf("%s: got namespace syntax %v, want %v", c.name, syntax, c.syntax)
+		}
+	}
+}
+
+func TestMigrateProfileError(t *testing.T) {
+	cases := []struct {
+		content, err string
+	}{
+		{"version: 4\napps: []", "newer than 3"},
+		{"version: two\napps: []", "must be a number"},
+		{"version: -1\napps: []", "must be a number"},
+		{"apps: [", "yaml"},
+	}
+	for _, c := range cases {
+		if _, _, _, err := migrateProfile([]byte(c.content)); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: got error %v, want %q", c.content, err, c.err)
+		}
+	}
+}
+
+func TestMigrateConfDirFile(t *testing.T) {
+	content := "apps:\n- appId: a\n  allInOne: false\n  namespace: [application]"
+	cases := []struct {
+		name, content string
+		version       int
+		syntax        string
+		err           string
+	}{
+		{"main profile version", content, 2, "env", ""},
+		{"current main profile", content, 3, "", ""},
+		{"own version", "version: 3\n" + content, 2, "", ""},
+		{"own older version", "version: 2\n" + content, 3, "env", ""},
+		{"invalid version", "version: 0\n" + content, 3, "", "must be a number between 1 and 3"},
+	}
+	for _, c := range cases {
+		migrated, err := migrateConfDirFile("conf.d/a.yaml", []byte(c.content), c.version, &Client{AllInOne: true})
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got error %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		fragment := &confDirProfile{}
+		if err == nil {
+			err = yaml.UnmarshalStrict(migrated, fragment)
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if got := fragment.Apps[0].Namespaces[0].Syntax; got != c.syntax {
+			t.Errorf("%s: got syntax %q, want %q", c.name, got, c.syntax)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package boot
+
+import (
+	"fmt"
+	"github.com/2345tech/apollo-agent/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Namespace 应用下的namespace，配置中可以是字符串（namespace名称），也可以是包含单独配置的对象
+type Namespace struct {
+	Name         string               `yaml:"name"`
+	PollOrWatch  string               `yaml:"pollOrWatch,omitempty"`
+	PollInterval time.Duration        `yaml:"pollInterval,omitempty"`
+	AllInOne     *bool                `yaml:"allInOne,omitempty"`
+	File         string               `yaml:"file,omitempty"`
+	Syntax       string               `yaml:"syntax,omitempty"`
+	FileMode     FileMode             `yaml:"fileMode,omitempty"`
+	Owner        string               `yaml:"owner,omitempty"`
+	Optional     bool                 `yaml:"optional,omitempty"`
+	Template     string               `yaml:"template,omitempty"`
+	Transform    *util.TransformRules `yaml:"transform,omitempty"`
+
+	tmpl *template.Template
+}
+
+func (n *Namespace) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*n = Namespace{Name: name}
+		return nil
+	}
+	type plain Namespace
+	return unmarshal((*plain)(n))
+}
+
+// MarshalYAML 只有名称时输出为字符串，与旧版本配置保持一致
+func (n *Namespace) MarshalYAML() (interface{}, error) {
+	if (*n == Namespace{Name: n.Name}) {
+		return n.Name, nil
+	}
+	type plain Namespace
+	return (*plain)(n), nil
+}
+
+// FileMode 八进制的文件权限，yaml中未加引号的 0640 会被解析为八进制整数，统一转换为 "0640"
+type FileMode string
+
+func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var perm int
+	if err := unmarshal(&perm); err == nil {
+		*m = FileMode(fmt.Sprintf("0%o", perm))
+		return nil
+	}
+	var mode string
+	if err := unmarshal(&mode); err != nil {
+		return err
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+// resolve 未配置file时使用应用的namespaceFile（默认为{namespace}），相对路径相对于inOneFile所在目录；
+// 未配置syntax时使用namespace格式对应的文件格式，单独环境变量配置的应用没有版本，properties仍按版本2写为dotEnv
+func (n *Namespace) resolve(app *App) {
+	n.File = expandNamespacePath(strOr(n.File, app.NamespaceFile), app, n)
+	if !filepath.IsAbs(n.File) {
+		n.File = filepath.Join(filepath.Dir(app.InOneFile), n.File)
+	}
+	name := util.ParseNSName(n.Name)
+	if app.fromEnvVar && name.IsProperties() {
+		n.Syntax = strOr(n.Syntax, util.F_ENV)
+	}
+	n.Syntax = strOr(n.Syntax, name.Syntax())
+}
+
+// transform 编译namespace的transform，未配置时使用应用的transform，都未配置时返回nil
+func (n *Namespace) transform(app *App) (*util.KeyTransform, error) {
+	rules := n.Transform
+	if rules == nil {
+		rules = app.Transform
+	}
+	if rules == nil {
+		return nil, nil
+	}
+	return util.NewKeyTransform(*rules)
+}
+
+// isKeyValue namespace是否按key/value写入：properties格式，或按flattenSeparator展平且不写为自身格式、xml、txt的json、yaml
+func (n *Namespace) isKeyValue(app *App) bool {
+	name := util.ParseNSName(n.Name)
+	if name.IsProperties() {
+		return true
+	}
+	if app.FlattenSeparator == "" || !name.IsStructured() {
+		return false
+	}
+	syntax := strings.ToLower(n.Syntax)
+	if *n.AllInOne {
+		syntax = strings.ToLower(app.Syntax)
+		return syntax != util.F_XML && syntax != util.F_TXT
+	}
+	switch syntax {
+	case name.Format, util.F_XML, util.F_TXT:
+		return false
+	}
+	return true
+}
+
+// perm 解析fileMode、owner，未配置时返回0、-1、-1
+func (n *Namespace) perm() (mode os.FileMode, uid, gid int, err error) {
+	uid, gid = -1, -1
+	if n.FileMode != "" {
+		if mode, err = util.ParseFileMode(string(n.FileMode)); err != nil {
+			return
+		}
+	}
+	if n.Owner != "" {
+		uid, gid, err = util.LookupOwner(n.Owner)
+	}
+	return
+}
+
+func namespacesOf(names []string) []*Namespace {
+	nss := make([]*Namespace, 0, len(names))
+	for _, name := range names {
+		nss = append(nss, &Namespace{Name: name})
+	}
+	return nss
+}
+
+func namespaceNames(app *App) []string {
+	names := make([]string, 0, len(app.Namespaces))
+	for _, ns := range app.Namespaces {
+		names = append(names, ns.Name)
+	}
+	return names
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,83 @@
+package boot
+
+import (
+	"fmt"
+	"github.com/2345tech/apollo-agent/util"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	_placeholderAppId         = "{appId}"
+	_placeholderCluster       = "{cluster}"
+	_placeholderNamespace     = "{namespace}"
+	_placeholderNamespaceBase = "{namespaceBase}"
+
+	_defaultNamespaceFile = _placeholderNamespace
+)
+
+var placeholderRegexp = regexp.MustCompile(`\{[A-Za-z]+\}`)
+
+// expandAppPath 替换路径中的{appId}、{cluster}，用于inOneFile
+func expandAppPath(path string, app *App) string {
+	return strings.NewReplacer(
+		_placeholderAppId, app.AppId,
+		_placeholderCluster, app.Cluster,
+	).Replace(path)
+}
+
+// expandNamespacePath 替换路径中的{appId}、{cluster}、{namespace}、{namespaceBase}，用于namespace独立文件
+func expandNamespacePath(path string, app *App, ns *Namespace) string {
+	return strings.NewReplacer(
+		_placeholderAppId, app.AppId,
+		_placeholderCluster, app.Cluster,
+		_placeholderNamespace, ns.Name,
+		_placeholderNamespaceBase, util.ParseNSName(ns.Name).Base,
+	).Replace(path)
+}
+
+// unknownPlaceholders 替换后仍残留的占位符
+func unknownPlaceholders(path string) []string {
+	return placeholderRegexp.FindAllString(path, -1)
+}
+
+// outputFileErrors 检查所有应用的输出文件，两个app/namespace写入同一个文件时返回错误
+func (p *Profile) outputFileErrors() []string {
+	writers := make(map[string][]string)
+	for _, app := range p.Apps {
+		inOne := make([]string, 0)
+		for _, ns := range app.Namespaces {
+			if ns.AllInOne == nil {
+				continue
+			}
+			if *ns.AllInOne {
+				inOne = append(inOne, ns.Name)
+				continue
+			}
+			file := outputKey(ns.File)
+			writers[file] = append(writers[file], app.AppId+"/"+app.Cluster+"/"+ns.Name)
+		}
+		if len(inOne) > 0 {
+			file := outputKey(app.InOneFile)
+			writers[file] = append(writers[file], app.AppId+"/"+app.Cluster+"/"+strings.Join(inOne, ","))
+		}
+	}
+
+	errs := make([]string, 0)
+	for file, names := range writers {
+		if len(names) > 1 {
+			errs = append(errs, fmt.Sprintf("output file %s is written by %s", file, strings.Join(names, " and ")))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func outputKey(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return filepath.Clean(file)
+}
@@ -0,0 +1,69 @@
+package boot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testProfile 按加载配置文件的流程解析并填充默认值，不做校验
+func testProfile(t *testing.T, content string) *Profile {
+	profile := &Profile{}
+	if err := yaml.Unmarshal([]byte(content), profile); err != nil {
+		t.Fatalf("unmarshal %q error, %v", content, err)
+	}
+	profile.wrapper()
+	profile.inherit()
+	return profile
+}
+
+func TestOutputFileErrors(t *testing.T) {
+	cases := []struct {
+		name, content string
+		errs          []string
+	}{
+		{"distinct files", "apps:\n- appId: a\n  inOneFile: /tmp/a.env\n- appId: b\n  inOneFile: /tmp/{appId}.env", []string{}},
+		{"same inOneFile", "apps:\n- appId: a\n  inOneFile: /tmp/app.env\n- appId: b\n  inOneFile: /tmp/../tmp/app.env",
+			[]string{"output file /tmp/app.env is written by a/default/application.properties and b/default/application.properties"}},
+		{"cluster placeholder", "apps:\n- appId: a\n  inOneFile: /tmp/{appId}-{cluster}.env\n" +
+			"- appId: a\n  cluster: dev\n  inOneFile: /tmp/{appId}-{cluster}.env", []string{}},
+		{"namespace file and inOneFile", "apps:\n- appId: a\n  inOneFile: /tmp/a/db.php\n  namespaceFile: /tmp/{appId}/{namespace}\n" +
+			"  namespace:\n  - application\n  - {name: db.php, allInOne: false}",
+			[]string{"output file /tmp/a/db.php is written by a/default/db.php and a/default/application"}},
+		{"namespace base", "apps:\n- appId: a\n  allInOne: false\n  namespaceFile: /tmp/{namespaceBase}.txt\n" +
+			"  namespace: [db.json, db.yaml]",
+			[]string{"output file /tmp/db.txt is written by a/default/db.json and a/default/db.yaml"}},
+		{"sorted errors", "apps:\n- appId: a\n  inOneFile: /tmp/z.env\n- appId: b\n  inOneFile: /tmp/z.env\n" +
+			"- appId: c\n  inOneFile: /tmp/a.env\n- appId: d\n  inOneFile: /tmp/a.env", []string{
+			"output file /tmp/a.env is written by c/default/application.properties and d/default/application.properties",
+			"output file /tmp/z.env is written by a/default/application.properties and b/default/application.properties",
+		}},
+	}
+	for _, c := range cases {
+		if errs := testProfile(t, c.content).outputFileErrors(); !reflect.DeepEqual(errs, c.errs) {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, strings.Join(errs, "\n"), strings.Join(c.errs, "\n"))
+		}
+	}
+}
+
+func TestExpandNamespacePath(t *testing.T) {
+	app := &App{AppId: "app", Cluster: "dev"}
+	cases := []struct {
+		path, namespace, want string
+	}{
+		{"/tmp/{appId}/{cluster}/{namespace}", "db.json", "/tmp/app/dev/db.json"},
+		{"/tmp/{namespaceBase}.php", "db.json", "/tmp/db.php"},
+		{"/tmp/{namespaceBase}.ini", "TEST1.redis", "/tmp/TEST1.redis.ini"},
+		{"/tmp/{namespace}-{unknown}", "db", "/tmp/db-{unknown}"},
+	}
+	for _, c := range cases {
+		if got := expandNamespacePath(c.path, app, &Namespace{Name: c.namespace}); got != c.want {
+			t.Errorf("%s %s: got %q, want %q", c.path, c.namespace, got, c.want)
+		}
+	}
+	if ph := unknownPlaceholders("/tmp/db-{unknown}-{x}"); !reflect.DeepEqual(ph, []string{"{unknown}", "{x}"}) {
+		t.Errorf("got unknown placeholders %v", ph)
+	}
+}
@@ -18,7 +18,7 @@ type SignalLauncher struct {
 func NewSignal() *SignalLauncher {
 	return &SignalLauncher{
 		booted: false,
-		signal: make(chan os.Signal),
+		signal: make(chan os.Signal, 1),
 	}
 }
 
@@ -41,18 +41,44 @@ func (s *SignalLauncher) Stop() {
 }
 
 func (s *SignalLauncher) Shutdown() {
+	signal.Stop(s.signal)
 	close(s.signal)
 	s.booted = false
 	log.Println("[INFO] SignalLauncher Shutdown")
 }
 
+// watchOsSignal SIGHUP重新加载配置，SIGUSR1忽略releaseKey重新拉取所有namespace，SIGUSR2将worker状态输出到日志，
+// SIGTERM、SIGINT、SIGQUIT优雅退出；fetch命令没有signalBus，只处理退出信号，取消正在进行的拉取
 func (s *SignalLauncher) watchOsSignal() {
-	signal.Notify(s.signal, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-	for {
-		switch <-s.signal {
-		case syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT:
+	if s.agent.Args.Command == CmdFetch {
+		signal.Notify(s.signal, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	} else {
+		signal.Notify(s.signal, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT,
+			syscall.SIGUSR1, syscall.SIGUSR2)
+	}
+	for sig := range s.signal {
+		switch sig {
+		case syscall.SIGHUP:
+			log.Println("[INFO] agent get reload signal...")
+			s.agent.ConfigL.ProfileUpdate = true
+			s.agent.SigBus.RestartS <- struct{}{}
+
+		case syscall.SIGUSR1:
+			log.Println("[INFO] agent get refetch signal...")
+			s.agent.SigBus.RefetchS <- struct{}{}
+
+		case syscall.SIGUSR2:
+			log.Println("[INFO] agent get dump signal...")
+			s.agent.SigBus.DumpS <- struct{}{}
+
+		case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
 			log.Println("[INFO] agent get close signal...")
+			if s.agent.Args.Command == CmdFetch {
+				s.agent.Cancel()
+				return
+			}
 			s.agent.SigBus.StopS <- struct{}{}
+			return
 		}
 	}
 }
@@ -2,12 +2,22 @@ package common
 
 import (
 	"context"
+	"github.com/2345tech/apollo-agent/util"
+	"os"
+	"text/template"
 	"time"
 )
 
 const (
 	ModePoll  = "poll"
 	ModeWatch = "watch"
+	ModeOnce  = "once"
+)
+
+// allInOne文件中有namespace未拉取到时的写入策略
+const (
+	PartialWriteNever      = "never"      // 等待所有必须的namespace
+	PartialWriteAfterGrace = "afterGrace" // 启动宽限期过后，使用已拉取到的namespace写入
 )
 
 type AgentHandler interface {
@@ -15,21 +25,57 @@ type AgentHandler interface {
 	SetRunMode(mode string)
 	PostHandle(param *HandlerParam, ctx context.Context) error
 	AfterCompletion(ctx context.Context) error
+	Refetch(ctx context.Context) error
+	Dump(ctx context.Context) error
 }
 
 type HandlerParam struct {
 	Address  string
 	Cluster  string
 	ClientIp string
-	AllInOne bool
 	Apps     []*App
+	// Ready 所有应用的配置首次写入文件后回调
+	Ready func()
 }
 
 type App struct {
-	AppId        string
-	Namespaces   []string
-	Secret       string
+	AppId            string
+	Address          string
+	Cluster          string
+	ClientIp         string
+	Namespaces       []*Namespace
+	Secret           string
+	FileName         string
+	Syntax           string
+	PartialWrite     string
+	StartupGrace     time.Duration
+	Flat             bool
+	Nested           bool
+	NestSeparator    string
+	NestConflict     string
+	FlattenSeparator string
+	XMLRoot          string
+	EnvDialect       string
+	IniStrict        bool
+	PhpStyle         string
+	PhpTyped         bool
+	PhpStrictTypes   bool
+	Template         *template.Template
+}
+
+// Namespace 拉取方式、轮询周期、是否合并写入均已按 namespace > app > client 的优先级确定，
+// Transform 已按 namespace > app 的优先级确定，File、Syntax、FileMode、Uid、Gid、Template 仅对非合并写入的namespace生效
+type Namespace struct {
+	Name         string
+	Mode         string
 	PollInterval time.Duration
-	FileName     string
+	AllInOne     bool
+	File         string
 	Syntax       string
+	FileMode     os.FileMode
+	Uid          int
+	Gid          int
+	Optional     bool
+	Template     *template.Template
+	Transform    *util.KeyTransform
 }
@@ -1,22 +1,29 @@
 package main
 
 import (
+	"fmt"
 	"github.com/2345tech/apollo-agent/apollo"
 	"github.com/2345tech/apollo-agent/boot"
 	"log"
+	"os"
 )
 
 func main() {
 	agent := boot.New(
 		boot.WithLauncher(boot.NewLog()),
+		boot.WithLauncher(boot.NewLock()),
 		boot.WithLauncher(boot.NewProfile()),
 		boot.WithLauncher(boot.NewSignal()),
 	)
 
 	agent.Init().RegisterHandler(apollo.NewHandler())
 
+	// 启动失败或fetch未拉取到必须的namespace时以非0状态码退出
 	if err := agent.Start(); err != nil {
 		log.Println(err.Error())
-		panic("[PANIC] agent Start failed")
+		if *agent.Args.LogFile != os.Stderr.Name() {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		os.Exit(1)
 	}
 }
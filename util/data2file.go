@@ -3,14 +3,20 @@ package util
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -21,61 +27,242 @@ const (
 	F_YML  = "yml"
 	F_XML  = "xml"
 	F_TXT  = "txt"
+	F_JSON = "json"
+
+	F_PROPERTIES = "properties"
+	F_TOML       = "toml"
+	F_TEMPLATE   = "template"
 )
 
 const (
 	FilePerm = 0644
 )
 
-func NSSyntax(namespace string) string {
-	syntax := path.Ext(namespace)
-	if syntax != "" {
-		syntax = strings.Trim(syntax, ".")
+// RenderOptions 写入文件时的输出选项
+type RenderOptions struct {
+	// Flat 合并写入json、php时不按namespace分组，所有namespace的key写入同一层，同名key后面的namespace覆盖前面的
+	Flat bool
+	// Nested 写入php、json、yaml时按Separator将properties的key展开为多层结构
+	Nested bool
+	// Separator 展开key的分隔符，默认为 .
+	Separator string
+	// Conflict 展开时同一个key既有值又有下级key的处理方式，NestConflictFlat或NestConflictError
+	Conflict string
+	// FlattenSeparator 不为空时json、yaml格式的namespace解析后按该分隔符展平为key/value，与properties一样写入key/value格式的文件
+	FlattenSeparator string
+	// XMLRoot 合并写入xml时包裹所有namespace内容的根元素名称，默认为config
+	XMLRoot string
+	// EnvDialect 写入dotEnv时的方言，决定引号及转义规则，默认为EnvDialectPlain
+	EnvDialect string
+	// StrictINI 按parse_ini_file的语法写入ini，值加引号并转义，Nested时按key拆分子区块
+	StrictINI bool
+	// PHP 写入php时的数组写法、类型推断及strict_types
+	PHP PHPEncoder
+	// Template syntax为template时使用的模板，TemplateMeta为执行模板时的应用信息
+	Template     *template.Template
+	TemplateMeta TemplateMeta
+	// Transforms 按namespace名称配置的key过滤及改名规则，只对按key/value写入的namespace生效
+	Transforms map[string]*KeyTransform
+}
+
+// isKeyValue namespace是否按key/value写入
+func (opts RenderOptions) isKeyValue(namespace string) bool {
+	name := ParseNSName(namespace)
+	return name.IsProperties() || (opts.FlattenSeparator != "" && name.IsStructured())
+}
+
+// keyValue 需要展平的namespace解析内容后返回key/value，按key/value写入的namespace再按Transforms过滤、改名，
+// 其他namespace原样返回
+func (opts RenderOptions) keyValue(namespace string, data map[string]string) (map[string]string, error) {
+	name := ParseNSName(namespace)
+	if opts.FlattenSeparator != "" && name.IsStructured() {
+		flat, err := FlattenContent(name.Format, data["content"], opts.FlattenSeparator)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: %s", namespace, err.Error())
+		}
+		data = flat
+	}
+	if transform := opts.Transforms[namespace]; transform != nil && opts.isKeyValue(namespace) {
+		transformed, err := transform.Apply(data)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s transform: %s", namespace, err.Error())
+		}
+		data = transformed
+	}
+	return data, nil
+}
+
+// shape properties的key/value按选项转换为写入的结构
+func (opts RenderOptions) shape(data map[string]string) (interface{}, error) {
+	if !opts.Nested {
+		return data, nil
 	}
+	return NestKeys(data, opts.Separator, opts.Conflict)
+}
+
+// SupportSyntax 是否为支持的文件格式
+func SupportSyntax(syntax string) bool {
 	switch strings.ToLower(syntax) {
-	case F_ENV, F_INI, F_PHP, F_YAML, F_YML, F_XML, F_TXT:
-		return strings.ToLower(syntax)
+	case F_ENV, F_INI, F_PHP, F_YAML, F_YML, F_XML, F_TXT, F_JSON, F_PROPERTIES, F_TOML, F_TEMPLATE:
+		return true
 	default:
-		return F_ENV
+		return false
 	}
 }
 
-// SingleNSInOneFile 将单独一个NS配置数据写入一个文件
-func SingleNSInOneFile(fileName, suffix string, data map[string]string) error {
+// SingleNSInOneFile 将单独一个NS配置数据写入一个文件，php、json、yaml写入properties的namespace时按key/value转换，
+// 其他格式的namespace写入原内容；json、yaml格式的namespace写为自身格式或txt时不展平
+func SingleNSInOneFile(fileName, suffix, namespace string, data map[string]string, opts RenderOptions) error {
 	var content string
-	switch strings.ToLower(suffix) {
-	case F_ENV, F_INI:
-		content, _ = Marshal(data)
+	var err error
+	var shaped interface{}
+	suffix = strings.ToLower(suffix)
+	if suffix == ParseNSName(namespace).Format || suffix == F_XML || suffix == F_TXT {
+		opts.FlattenSeparator = ""
+	}
+	if data, err = opts.keyValue(namespace, data); err != nil {
+		return err
+	}
+	isKeyValue := opts.isKeyValue(namespace)
+	switch suffix {
+	case F_ENV:
+		content, err = MarshalDotEnv(data, opts.EnvDialect)
+	case F_INI:
+		if opts.StrictINI {
+			content, err = strictINI([]iniSection{{data: data}}, opts)
+		} else {
+			content, _ = Marshal(data)
+		}
 	case F_PHP:
-		content = "<?php\n\nreturn " + GoTypeToPHPCode(data) + ";\n"
-	case F_YAML, F_YML, F_XML, F_TXT:
+		if shaped, err = opts.shape(data); err == nil {
+			content = opts.PHP.File(shaped)
+		}
+	case F_JSON:
+		if !isKeyValue {
+			content = data["content"]
+		} else if shaped, err = opts.shape(data); err == nil {
+			content, err = GoTypeToJSON(shaped)
+		}
+	case F_YAML, F_YML:
+		if !isKeyValue {
+			content = data["content"]
+		} else if shaped, err = opts.shape(data); err == nil {
+			content, err = goTypeToYAML(shaped)
+		}
+	case F_PROPERTIES:
+		content = MarshalProperties(data)
+	case F_TOML:
+		content, err = namespaceToTOML(namespace, data, opts)
+	case F_TEMPLATE:
+		content, err = ExecuteTemplate(opts.Template, TemplateData{
+			TemplateMeta: opts.TemplateMeta,
+			Namespace:    namespace,
+			Namespaces:   []string{namespace},
+			Data:         map[string]map[string]string{namespace: data},
+			Config:       data,
+		})
+	case F_XML, F_TXT:
 		content = data["content"]
 	}
+	if err != nil {
+		return err
+	}
 	return WriteFile(fileName, content, FilePerm)
 }
 
+// namespaceToTOML key/value的namespace按选项转换，json、yaml格式的namespace解析后转换
+func namespaceToTOML(namespace string, data map[string]string, opts RenderOptions) (string, error) {
+	name := ParseNSName(namespace)
+	switch {
+	case opts.isKeyValue(namespace):
+		shaped, err := opts.shape(data)
+		if err != nil {
+			return "", err
+		}
+		return GoTypeToTOML(toTree(shaped)), nil
+	case name.IsStructured():
+		tree, err := ParseStructured(name.Format, data["content"])
+		if err != nil {
+			return "", err
+		}
+		return GoTypeToTOML(tree), nil
+	default:
+		return "", fmt.Errorf("namespace %s of format %s cannot be written to toml", namespace, name.Format)
+	}
+}
+
 // MultiNSInOneFile 将多个NS配置数据写入到一个文件中
-func MultiNSInOneFile(fileName, suffix string, nss []string, multiData map[string]map[string]string) error {
+func MultiNSInOneFile(fileName, suffix string, nss []string, multiData map[string]map[string]string,
+	opts RenderOptions) error {
+	content, err := renderMultiNS(suffix, nss, multiData, opts)
+	if err != nil {
+		return err
+	}
+	return WriteFile(fileName, content, FilePerm)
+}
+
+// renderMultiNS 按suffix格式生成多个namespace合并后的文件内容
+func renderMultiNS(suffix string, nss []string, multiData map[string]map[string]string,
+	opts RenderOptions) (string, error) {
 	var content string
-	switch strings.ToLower(suffix) {
+	var err error
+	suffix = strings.ToLower(suffix)
+	if raw, ok := singleRawNS(suffix, nss, multiData, opts); ok {
+		return raw, nil
+	}
+	if suffix != F_XML && suffix != F_TXT {
+		if multiData, err = flattenMultiData(multiData, nss, opts); err != nil {
+			return "", err
+		}
+	}
+	switch suffix {
 	case F_ENV:
-		content = multiDataToDotENV(multiData, nss)
+		content, err = multiDataToDotENV(multiData, nss, opts.EnvDialect)
 	case F_INI:
-		content = multiDataToINI(multiData, nss)
+		if opts.StrictINI {
+			content, err = multiDataToStrictINI(multiData, nss, opts)
+		} else {
+			content = multiDataToINI(multiData, nss)
+		}
 	case F_PHP:
-		content = multiDataToPHP(multiData, nss)
-	case F_YAML, F_YML, F_XML, F_TXT:
+		content, err = multiDataToPHP(multiData, nss, opts)
+	case F_JSON:
+		var merged map[string]interface{}
+		if merged, err = mergeMultiData(multiData, nss, suffix, opts); err == nil {
+			content, err = GoTypeToJSON(merged)
+		}
+	case F_YAML, F_YML:
+		var merged map[string]interface{}
+		if merged, err = mergeMultiData(multiData, nss, suffix, opts); err == nil {
+			content, err = goTypeToYAML(merged)
+		}
+	case F_PROPERTIES:
+		content = multiDataToProperties(multiData, nss)
+	case F_TOML:
+		var merged map[string]interface{}
+		if merged, err = mergeMultiData(multiData, nss, suffix, opts); err == nil {
+			content = GoTypeToTOML(merged)
+		}
+	case F_TEMPLATE:
+		content, err = multiDataToTemplate(multiData, nss, opts)
+	case F_XML:
+		content, err = multiDataToXML(multiData, nss, opts)
+	case F_TXT:
 		content = multiDataToTXT(multiData, nss)
 	}
-	return WriteFile(fileName, content, FilePerm)
+	return content, err
 }
 
-// WriteFile 将内容写入文件
+// WriteFile 将内容写入文件，文件所在目录不存在时自动创建
 func WriteFile(filename, content string, perm os.FileMode) error {
 	var m sync.Mutex
 	m.Lock()
 	defer m.Unlock()
 
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
 	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
@@ -91,28 +278,26 @@ func WriteFile(filename, content string, perm os.FileMode) error {
 }
 
 // multiDataToDotENV 配置数据转换为dotEnv内容
-func multiDataToDotENV(multiData map[string]map[string]string, nss []string) string {
+func multiDataToDotENV(multiData map[string]map[string]string, nss []string, dialect string) (string, error) {
 	content := make([]string, 0)
 	// 遍历配置数据，拼接配置文件内容
 	for _, namespace := range nss {
 		if data, ok := multiData[namespace]; ok {
-			sortKeys := make([]string, 0, len(data))
-			for k := range data {
-				sortKeys = append(sortKeys, k)
-			}
-			sort.Strings(sortKeys) // 进行key自然升序
-
 			// 写一行注释，提高.env文件可读性，用于快速区分namespace配置区块
-			content = append(content, "###"+trimNSSuffix(namespace)+"###")
+			content = append(content, "###"+ParseNSName(namespace).Base+"###")
 
-			for _, key := range sortKeys {
-				content = append(content, fmt.Sprintf(`%s=%s`, key, data[key]))
+			lines, err := MarshalDotEnv(data, dialect)
+			if err != nil {
+				return "", fmt.Errorf("namespace %s: %s", namespace, err.Error())
+			}
+			if lines != "" {
+				content = append(content, lines)
 			}
 			content = append(content, "\n") //增加一个换行进行区分
 		}
 	}
 
-	return strings.Join(content, "\n")
+	return strings.Join(content, "\n"), nil
 }
 
 // multiDataToINI 配置数据转换为ini内容
@@ -128,7 +313,7 @@ func multiDataToINI(multiData map[string]map[string]string, nss []string) string
 			sort.Strings(sortKeys) // 进行key自然升序
 
 			// 根据namespace分配置区块
-			content = append(content, "["+trimNSSuffix(namespace)+"]")
+			content = append(content, "["+ParseNSName(namespace).Base+"]")
 
 			for _, key := range sortKeys {
 				content = append(content, fmt.Sprintf(`%s=%s`, key, data[key]))
@@ -140,16 +325,248 @@ func multiDataToINI(multiData map[string]map[string]string, nss []string) string
 	return strings.Join(content, "\n")
 }
 
-// multiDataToPHP 配置数据转换为ini内容
-func multiDataToPHP(multiData map[string]map[string]string, nss []string) string {
-	content := make(map[string]map[string]string)
+// multiDataToTemplate 模板中可以使用所有namespace的配置
+func multiDataToTemplate(multiData map[string]map[string]string, nss []string, opts RenderOptions) (string, error) {
+	data := make(map[string]map[string]string, len(nss))
+	config := make(map[string]string)
+	for _, namespace := range nss {
+		if nsData, ok := multiData[namespace]; ok {
+			data[namespace] = nsData
+			for key, value := range nsData {
+				config[key] = value
+			}
+		}
+	}
+	return ExecuteTemplate(opts.Template, TemplateData{
+		TemplateMeta: opts.TemplateMeta,
+		Namespaces:   nss,
+		Data:         data,
+		Config:       config,
+	})
+}
+
+// multiDataToProperties 各namespace依次写入，以注释区分，读取时同名key以后面的namespace为准
+func multiDataToProperties(multiData map[string]map[string]string, nss []string) string {
+	content := make([]string, 0)
+	for _, namespace := range nss {
+		if data, ok := multiData[namespace]; ok {
+			content = append(content, "# "+ParseNSName(namespace).Base)
+			if lines := MarshalProperties(data); lines != "" {
+				content = append(content, lines)
+			}
+			content = append(content, "")
+		}
+	}
+	return strings.Join(content, "\n")
+}
+
+// multiDataToStrictINI 每个namespace一个区块，区块名称为去掉格式后缀的名称
+func multiDataToStrictINI(multiData map[string]map[string]string, nss []string, opts RenderOptions) (string, error) {
+	sections := make([]iniSection, 0, len(nss))
+	for _, namespace := range nss {
+		if data, ok := multiData[namespace]; ok {
+			sections = append(sections, iniSection{name: ParseNSName(namespace).Base, data: data})
+		}
+	}
+	return strictINI(sections, opts)
+}
+
+// multiDataToPHP 配置数据转换为php内容
+func multiDataToPHP(multiData map[string]map[string]string, nss []string, opts RenderOptions) (string, error) {
+	grouped, err := groupByNamespace(multiData, nss, opts)
+	if err != nil {
+		return "", err
+	}
+	return opts.PHP.File(grouped), nil
+}
+
+// singleRawNS 只写入一个与inOneFile格式相同的yaml、xml格式namespace时原样写入其内容，
+// 保留注释、key的顺序及锚点，xml也不包裹根元素，与合并写入之前的版本一致
+func singleRawNS(suffix string, nss []string, multiData map[string]map[string]string, opts RenderOptions) (string, bool) {
+	if len(nss) != 1 || opts.isKeyValue(nss[0]) {
+		return "", false
+	}
+	data, ok := multiData[nss[0]]
+	if !ok {
+		return "", false
+	}
+	format := ParseNSName(nss[0]).Format
+	switch suffix {
+	case F_YAML, F_YML:
+		ok = format == NS_YAML || format == NS_YML
+	case F_XML:
+		ok = format == NS_XML
+	default:
+		ok = false
+	}
+	return data["content"], ok
+}
+
+// flattenMultiData 需要展平的namespace解析为key/value，按key/value写入的namespace按Transforms过滤、改名，不修改原数据
+func flattenMultiData(multiData map[string]map[string]string, nss []string,
+	opts RenderOptions) (map[string]map[string]string, error) {
+	if opts.FlattenSeparator == "" && len(opts.Transforms) == 0 {
+		return multiData, nil
+	}
+	flattened := make(map[string]map[string]string, len(multiData))
+	for namespace, data := range multiData {
+		flattened[namespace] = data
+	}
+	for _, namespace := range nss {
+		if data, ok := multiData[namespace]; ok {
+			kv, err := opts.keyValue(namespace, data)
+			if err != nil {
+				return nil, err
+			}
+			flattened[namespace] = kv
+		}
+	}
+	return flattened, nil
+}
+
+// mergeMultiData 按namespace列表的顺序深度合并，后面的namespace覆盖前面的：
+// key/value的namespace按groupByNamespace的规则分组或合并到同一层，json、yaml格式的namespace解析后合并到顶层
+func mergeMultiData(multiData map[string]map[string]string, nss []string, suffix string,
+	opts RenderOptions) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, namespace := range nss {
+		data, ok := multiData[namespace]
+		if !ok {
+			continue
+		}
+		var doc map[string]interface{}
+		var err error
+		name := ParseNSName(namespace)
+		switch {
+		case opts.isKeyValue(namespace):
+			var grouped interface{}
+			if grouped, err = groupByNamespace(multiData, []string{namespace}, opts); err == nil {
+				doc = toTree(grouped)
+			}
+		case name.IsStructured():
+			if doc, err = ParseStructured(name.Format, data["content"]); err != nil {
+				err = fmt.Errorf("namespace %s: %s", namespace, err.Error())
+			}
+		default:
+			err = fmt.Errorf("namespace %s of format %s cannot be merged into %s", namespace, name.Format, suffix)
+		}
+		if err != nil {
+			return nil, err
+		}
+		MergeTree(merged, doc)
+	}
+	return merged, nil
+}
+
+// toTree 将分组后的key/value转换为可以深度合并的结构
+func toTree(v interface{}) map[string]interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if _, ok := child.(map[string]string); ok {
+				value[key] = toTree(child)
+			}
+		}
+		return value
+	case map[string]string:
+		tree := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			tree[key] = child
+		}
+		return tree
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// multiDataToXML 各namespace的xml内容去掉声明后放到同一个根元素下
+func multiDataToXML(multiData map[string]map[string]string, nss []string, opts RenderOptions) (string, error) {
+	fragments := make([]string, 0, len(nss))
+	for _, namespace := range nss {
+		data, ok := multiData[namespace]
+		if !ok {
+			continue
+		}
+		if name := ParseNSName(namespace); name.Format != NS_XML {
+			return "", fmt.Errorf("namespace %s of format %s cannot be merged into xml", namespace, name.Format)
+		}
+		fragment, err := xmlFragment(data["content"])
+		if err != nil {
+			return "", fmt.Errorf("namespace %s: %s", namespace, err.Error())
+		}
+		fragments = append(fragments, fragment)
+	}
+	root := opts.XMLRoot
+	if root == "" {
+		root = DefaultXMLRoot
+	}
+	return wrapXML(root, fragments), nil
+}
+
+// groupByNamespace 按namespace（去掉格式后缀的名称）分组，flat时所有namespace的key合并到同一层，同名key后面的namespace覆盖前面的；
+// nested时分组或合并之后再展开key
+func groupByNamespace(multiData map[string]map[string]string, nss []string, opts RenderOptions) (interface{}, error) {
+	if opts.Flat {
+		content := make(map[string]string)
+		for _, namespace := range nss {
+			for key, value := range multiData[namespace] {
+				content[key] = value
+			}
+		}
+		return opts.shape(content)
+	}
+	content := make(map[string]interface{})
 	for _, namespace := range nss {
 		if data, ok := multiData[namespace]; ok {
-			content[trimNSSuffix(namespace)] = data
+			base := ParseNSName(namespace).Base
+			shaped, err := opts.shape(data)
+			if err != nil {
+				return nil, fmt.Errorf("namespace %s: %s", namespace, err.Error())
+			}
+			content[base] = shaped
 		}
 	}
+	return content, nil
+}
 
-	return "<?php\n\nreturn " + GoTypeToPHPCode(content) + ";\n"
+// goTypeToYAML map的key按升序输出，内容不变时输出不变。
+// yaml.v2会把json.Number转换为float64，超出uint64的整数先替换为占位字符串，输出后再换回原始的数字
+func goTypeToYAML(v interface{}) (string, error) {
+	numbers := make(map[string]string)
+	prefix := fmt.Sprintf("apollo-agent-number-%x-", time.Now().UnixNano())
+	content, err := yaml.Marshal(replaceNumbers(v, prefix, numbers))
+	if err != nil {
+		return "", err
+	}
+	out := string(content)
+	for token, number := range numbers {
+		out = strings.Replace(out, token, number, 1)
+	}
+	return out, nil
+}
+
+// replaceNumbers 返回将json.Number替换为占位字符串后的副本，占位字符串到数字的映射记录在numbers中
+func replaceNumbers(v interface{}, prefix string, numbers map[string]string) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		token := prefix + strconv.Itoa(len(numbers))
+		numbers[token] = value.String()
+		return token
+	case map[string]interface{}:
+		replaced := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			replaced[key] = replaceNumbers(child, prefix, numbers)
+		}
+		return replaced
+	case []interface{}:
+		replaced := make([]interface{}, len(value))
+		for i, child := range value {
+			replaced[i] = replaceNumbers(child, prefix, numbers)
+		}
+		return replaced
+	default:
+		return value
+	}
 }
 
 // multiDataToTXT 配置数据转换为txt内容
@@ -166,16 +583,6 @@ func multiDataToTXT(multiData map[string]map[string]string, nss []string) string
 	return strings.Join(content, "\n")
 }
 
-// trimNSSuffix 切除namespace后缀
-func trimNSSuffix(namespace string) string {
-	ns := strings.Split(namespace, ".")
-	if len(ns) > 0 {
-		return ns[0]
-	} else {
-		return namespace
-	}
-}
-
 // HashFileMd5 获取文件md5值
 func HashFileMd5(filePath string) (string, error) {
 	var md5Sum string
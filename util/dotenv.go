@@ -0,0 +1,118 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dotEnv写入的方言，不同程序读取.env文件的引号及转义规则不同
+const (
+	// EnvDialectPlain 原样写入 key=value，不加引号也不转义
+	EnvDialectPlain = "plain"
+	// EnvDialectPosix 写为 export KEY='value'，可以被sh、bash直接source
+	EnvDialectPosix = "posix"
+	// EnvDialectDocker docker run --env-file 不处理引号及转义，值原样写入，不能包含换行
+	EnvDialectDocker = "docker"
+	// EnvDialectSystemd systemd的EnvironmentFile，需要时使用双引号，值中可以包含换行
+	EnvDialectSystemd = "systemd"
+	// EnvDialectPHP vlucas/phpdotenv（Laravel），需要时使用双引号并转义
+	EnvDialectPHP = "phpdotenv"
+)
+
+var (
+	shellNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	phpNameRegexp   = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+	// safeValueRegexp 只包含这些字符的值在各方言中都不需要引号
+	safeValueRegexp = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]+$`)
+)
+
+// SupportEnvDialect 是否为支持的dotEnv方言
+func SupportEnvDialect(dialect string) bool {
+	switch dialect {
+	case EnvDialectPlain, EnvDialectPosix, EnvDialectDocker, EnvDialectSystemd, EnvDialectPHP:
+		return true
+	default:
+		return false
+	}
+}
+
+// FormatEnvLine 按方言输出一行dotEnv配置，key在该方言中不合法或值无法表示时返回错误
+func FormatEnvLine(dialect, key, value string) (string, error) {
+	switch dialect {
+	case EnvDialectPosix:
+		if !shellNameRegexp.MatchString(key) {
+			return "", fmt.Errorf("key %q is not a valid shell variable name", key)
+		}
+		return "export " + key + "=" + posixQuote(value), nil
+	case EnvDialectDocker:
+		if key == "" || strings.HasPrefix(key, "#") || strings.ContainsAny(key, "= \t\r\n") {
+			return "", fmt.Errorf("key %q cannot be written to a docker env-file", key)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return "", fmt.Errorf("value of key %q contains a line break, which a docker env-file cannot hold", key)
+		}
+		return key + "=" + value, nil
+	case EnvDialectSystemd:
+		if !shellNameRegexp.MatchString(key) {
+			return "", fmt.Errorf("key %q is not a valid environment variable name for systemd", key)
+		}
+		return key + "=" + systemdQuote(value), nil
+	case EnvDialectPHP:
+		if !phpNameRegexp.MatchString(key) {
+			return "", fmt.Errorf("key %q is not a valid phpdotenv variable name", key)
+		}
+		return key + "=" + phpDotEnvQuote(value), nil
+	default:
+		return key + "=" + value, nil
+	}
+}
+
+// posixQuote 单引号中的内容不做任何展开，值中的单引号先结束引号、转义后再重新开始引号
+func posixQuote(value string) string {
+	if safeValueRegexp.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// systemdQuote 双引号中只有 \ " ` $ 需要转义，换行原样保留
+func systemdQuote(value string) string {
+	if safeValueRegexp.MatchString(value) {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// phpDotEnvQuote 双引号中转义 \ " $ 及换行，避免phpdotenv展开 ${VAR}
+func phpDotEnvQuote(value string) string {
+	if safeValueRegexp.MatchString(value) {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// MarshalDotEnv 按方言输出dotEnv内容，key按升序排列
+func MarshalDotEnv(envMap map[string]string, dialect string) (string, error) {
+	lines := make([]string, 0, len(envMap))
+	for _, key := range sortedKeys(envMap) {
+		line, err := FormatEnvLine(dialect, key, envMap[key])
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func sortedKeys(data map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
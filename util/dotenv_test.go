@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+)
+
+// dotEnvCases raw为true的值在不加引号的plain、docker方言中也能原样读回，不加引号时 # 之后为注释
+var dotEnvCases = []struct {
+	value string
+	raw   bool
+}{
+	{"127.0.0.1", true},
+	{"", true},
+	{"it's", true},
+	{"a b  c", true},
+	{`C:\path\to`, true},
+	{`say "hi"`, true},
+	{"a=b:c", true},
+	{"hash#x", false},
+	{"中文 value", true},
+	{"'quoted'", false},
+	{`"double"`, false},
+	{"line1\nline2", false},
+	{"multi\n\nblank lines\n", false},
+	{"cr\r\nlf", false},
+	{`\n is not a newline`, false},
+	{`ends with \`, false},
+	{"$HOME and ${USER}", false},
+	{"back`tick`", false},
+	{"hash # comment", false},
+	{" padded ", false},
+	{`mixed 'single' "double" \ $X`, false},
+}
+
+func TestDotEnvRoundTrip(t *testing.T) {
+	for _, dialect := range []string{EnvDialectPlain, EnvDialectPosix, EnvDialectDocker, EnvDialectSystemd, EnvDialectPHP} {
+		raw := dialect == EnvDialectPlain || dialect == EnvDialectDocker
+		data := make(map[string]string)
+		for i, c := range dotEnvCases {
+			if c.raw || !raw {
+				data[fmt.Sprintf("KEY_%d", i)] = c.value
+			}
+		}
+		content, err := MarshalDotEnv(data, dialect)
+		if err != nil {
+			t.Fatalf("%s: MarshalDotEnv error, %v", dialect, err)
+		}
+		parsed, err := Unmarshal(content)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal error, %v\n%s", dialect, err, content)
+		}
+		for key, value := range data {
+			if parsed[key] != value {
+				line, _ := FormatEnvLine(dialect, key, value)
+				t.Errorf("%s: %s = %q, got %q from %s", dialect, key, value, parsed[key], line)
+			}
+		}
+		if len(parsed) != len(data) {
+			t.Errorf("%s: got %d keys, want %d\n%s", dialect, len(parsed), len(data), content)
+		}
+	}
+}
+
+func TestFormatEnvLineErrors(t *testing.T) {
+	cases := []struct {
+		dialect, key, value string
+	}{
+		{EnvDialectPosix, "db.host", "x"},
+		{EnvDialectSystemd, "1KEY", "x"},
+		{EnvDialectDocker, "KEY", "a\nb"},
+		{EnvDialectDocker, "A B", "x"},
+		{EnvDialectPHP, "a-b", "x"},
+	}
+	for _, c := range cases {
+		if line, err := FormatEnvLine(c.dialect, c.key, c.value); err == nil {
+			t.Errorf("%s: %q=%q should fail, got %q", c.dialect, c.key, c.value, line)
+		}
+	}
+}
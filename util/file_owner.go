@@ -0,0 +1,83 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseFileMode 解析八进制的文件权限，如 0640
+func ParseFileMode(mode string) (os.FileMode, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("file mode %q must be octal permission bits like 0640", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// LookupOwner 解析 user[:group]，支持用户名、组名或数字id，未指定group时使用用户的主组
+func LookupOwner(owner string) (uid, gid int, err error) {
+	name, group := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return -1, -1, fmt.Errorf("owner %q: unknown user %q", owner, name)
+		}
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(u.Gid)
+	if group == "" {
+		return uid, gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		if g, err = user.LookupGroupId(group); err != nil {
+			return -1, -1, fmt.Errorf("owner %q: unknown group %q", owner, group)
+		}
+	}
+	gid, _ = strconv.Atoi(g.Gid)
+	return uid, gid, nil
+}
+
+// ApplyFileOwner 设置文件权限及属主，mode为0时不修改权限，uid、gid为-1时不修改属主
+func ApplyFileOwner(name string, mode os.FileMode, uid, gid int) error {
+	if mode != 0 {
+		if err := os.Chmod(name, mode); err != nil {
+			return err
+		}
+	}
+	if uid != -1 || gid != -1 {
+		return os.Chown(name, uid, gid)
+	}
+	return nil
+}
+
+// CreateFile 重新创建空文件并设置权限及属主（mode为0时为FilePerm），之后写入的内容不会以默认权限短暂可读
+func CreateFile(name string, mode os.FileMode, uid, gid int) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	perm := mode
+	if perm == 0 {
+		perm = FilePerm
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return ApplyFileOwner(name, mode, uid, gid)
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FlattenContent 解析json、yaml格式namespace的内容，按分隔符展平为key/value，
+// 如 {"db": {"hosts": ["h1", "h2"]}} 以 _ 展平为 db_hosts_0=h1、db_hosts_1=h2；null写为空字符串，空对象、空数组不输出
+func FlattenContent(format, content, separator string) (map[string]string, error) {
+	tree, err := ParseStructured(format, content)
+	if err != nil {
+		return nil, err
+	}
+	flat := make(map[string]string)
+	flattenValue(flat, "", separator, tree)
+	return flat, nil
+}
+
+func flattenValue(flat map[string]string, prefix, separator string, v interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + separator + key
+	}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			flattenValue(flat, join(key), separator, child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			flattenValue(flat, join(strconv.Itoa(i)), separator, child)
+		}
+	case nil:
+		if prefix != "" {
+			flat[prefix] = ""
+		}
+	default:
+		flat[prefix] = fmt.Sprint(value)
+	}
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"time"
 )
@@ -91,3 +92,35 @@ func Str(name, def string) string {
 	}
 	return value
 }
+
+// envRefRegex matches $${ (an escaped literal ${) or a ${NAME} / ${NAME:-default} reference.
+var envRefRegex = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ExpandEnv replaces ${NAME} and ${NAME:-default} in s with the value of
+// environment variable NAME. The default is used when NAME is missing or
+// empty. $${ is written as a literal ${. It also returns the names of
+// variables that were missing and had no default.
+func ExpandEnv(s string) (string, []string) {
+	missing := make([]string, 0)
+	expanded := envRefRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		match := envRefRegex.FindStringSubmatch(ref)
+		if value := os.Getenv(match[1]); value != "" {
+			return value
+		}
+		if match[2] == "" {
+			missing = append(missing, match[1])
+		}
+		return match[3]
+	})
+	return expanded, missing
+}
+
+// IsEnvRef reports whether s is exactly one ${NAME} or ${NAME:-default}
+// reference.
+func IsEnvRef(s string) bool {
+	loc := envRefRegex.FindStringSubmatchIndex(s)
+	return loc != nil && loc[0] == 0 && loc[1] == len(s) && loc[2] >= 0
+}
@@ -1,10 +1,10 @@
 package util
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"regexp"
@@ -13,8 +13,6 @@ import (
 	"strings"
 )
 
-const doubleQuoteSpecialChars = "\\\n\r\"!$`"
-
 // Load will read your env file(s) and load them into ENV for this process.
 //
 // Call this function as close as possible to the start of your program (ideally in main)
@@ -87,14 +85,27 @@ func Read(filenames ...string) (envMap map[string]string, err error) {
 func Parse(r io.Reader) (envMap map[string]string, err error) {
 	envMap = make(map[string]string)
 
-	var lines []string
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
 	}
 
-	if err = scanner.Err(); err != nil {
-		return
+	// 引号中的值可以包含换行（包括\r），引号未结束时与下一行合并
+	var lines []string
+	pending := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		if pending != "" {
+			line = pending + "\n" + line
+		}
+		if !isIgnoredLine(line) && quoteOpen(line) {
+			pending = line
+			continue
+		}
+		pending = ""
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+	}
+	if pending != "" {
+		lines = append(lines, strings.TrimSuffix(pending, "\r"))
 	}
 
 	for _, fullLine := range lines {
@@ -280,9 +291,41 @@ func parseLine(line string, envMap map[string]string) (key string, value string,
 	return
 }
 
+// quoteOpen 值以引号开始且到行尾引号仍未结束时返回true，引号外 # 之后为注释
+func quoteOpen(line string) bool {
+	i := strings.IndexAny(line, "=:")
+	if i < 0 {
+		return false
+	}
+	value := strings.TrimLeft(line[i+1:], " ")
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return false
+	}
+	var quote byte
+	for j := 0; j < len(value); j++ {
+		switch c := value[j]; {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			j++
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return false
+		}
+	}
+	return quote != 0
+}
+
 var (
-	singleQuotesRegex  = regexp.MustCompile(`\A'(.*)'\z`)
-	doubleQuotesRegex  = regexp.MustCompile(`\A"(.*)"\z`)
+	singleQuotesRegex  = regexp.MustCompile(`(?s)\A'(.*)'\z`)
+	doubleQuotesRegex  = regexp.MustCompile(`(?s)\A"(.*)"\z`)
 	escapeRegex        = regexp.MustCompile(`\\.`)
 	unescapeCharsRegex = regexp.MustCompile(`\\([^$])`)
 )
@@ -303,6 +346,11 @@ func parseValue(value string, envMap map[string]string) string {
 			value = value[1 : len(value)-1]
 		}
 
+		if singleQuotes != nil {
+			// sh中单引号内的 ' 写为 '\''
+			value = strings.ReplaceAll(value, `'\''`, `'`)
+		}
+
 		if doubleQuotes != nil {
 			// expand newlines
 			value = escapeRegex.ReplaceAllStringFunc(value, func(match string) string {
@@ -350,17 +398,3 @@ func isIgnoredLine(line string) bool {
 	trimmedLine := strings.TrimSpace(line)
 	return len(trimmedLine) == 0 || strings.HasPrefix(trimmedLine, "#")
 }
-
-func DoubleQuoteEscape(line string) string {
-	for _, c := range doubleQuoteSpecialChars {
-		toReplace := "\\" + string(c)
-		if c == '\n' {
-			toReplace = `\n`
-		}
-		if c == '\r' {
-			toReplace = `\r`
-		}
-		line = strings.Replace(line, string(c), toReplace, -1)
-	}
-	return line
-}
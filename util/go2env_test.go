@@ -0,0 +1,70 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalCompatible(t *testing.T) {
+	cases := []struct {
+		name, content string
+		want          map[string]string
+	}{
+		{"comments and blank lines", "# comment\n\n  # indented comment\nA=1\n\nB=2\n",
+			map[string]string{"A": "1", "B": "2"}},
+		{"inline comment", "A=value # comment\nB=a#b\nC=\"quoted # hash\" # comment\nD='single # hash'",
+			map[string]string{"A": "value", "B": "a", "C": "quoted # hash", "D": "single # hash"}},
+		{"export prefix", "export A=1\n  export B = two\nexportC=3",
+			map[string]string{"A": "1", "B": "two", "exportC": "3"}},
+		{"single quotes", `A='a $B \n "c"'` + "\nB='it'",
+			map[string]string{"A": `a $B \n "c"`, "B": "it"}},
+		{"double quotes", `A="a\nb\rc \"d\" \\e \$F"`,
+			map[string]string{"A": "a\nb\rc \"d\" \\e $F"}},
+		{"unquoted", `A=C:\path it's "x"` + "\nB=  padded  \nC=",
+			map[string]string{"A": `C:\path it's "x"`, "B": "padded", "C": ""}},
+		{"yaml style", "A: 1\nB: a=b\nC=x:y",
+			map[string]string{"A": "1", "B": "a=b", "C": "x:y"}},
+		{"expand variables", "A=1\nB=${A}-$A\nC='${A}'\nD=\"${A}\"\nE=\\$A\nF=$MISSING",
+			map[string]string{"A": "1", "B": "1-1", "C": "${A}", "D": "1", "E": "$A", "F": ""}},
+		{"crlf", "A=1\r\nB=\"two\"\r\nC='three'\r\n",
+			map[string]string{"A": "1", "B": "two", "C": "three"}},
+	}
+	for _, c := range cases {
+		got, err := Unmarshal(c.content)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestUnmarshalMultiLine(t *testing.T) {
+	cases := []struct {
+		name, content string
+		want          map[string]string
+	}{
+		{"double quotes", "A=\"line1\n\nline3 # not comment\n\"\nB=2",
+			map[string]string{"A": "line1\n\nline3 # not comment\n", "B": "2"}},
+		{"single quotes", "export A='it'\\''s\n$B'\nB=2",
+			map[string]string{"A": "it's\n$B", "B": "2"}},
+		{"escaped quote", "A=\"say \\\"hi\\\"\nnext\" # comment\nB=2",
+			map[string]string{"A": "say \"hi\"\nnext", "B": "2"}},
+		{"carriage return in quotes", "A=\"cr\r\nlf\"\r\nB=2\r\n",
+			map[string]string{"A": "cr\r\nlf", "B": "2"}},
+		{"quote inside unquoted value", "A=it's\nB=\"x\ny\"",
+			map[string]string{"A": "it's", "B": "x\ny"}},
+	}
+	for _, c := range cases {
+		got, err := Unmarshal(c.content)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
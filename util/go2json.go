@@ -0,0 +1,18 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// GoTypeToJSON 将Go数据转换为缩进格式的JSON，map的key按升序输出，内容不变时输出不变；不转义 <、>、&
+func GoTypeToJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
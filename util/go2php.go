@@ -2,95 +2,198 @@ package util
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 )
 
+// PHP数组的写法
 const (
-	arrayPrefix = "["
-	arraySuffix = "]"
-	arrayLink   = "=>"
-	arrayEnding = ","
+	// PHPStyleShort 短数组写法 [ 'key' => 'value', ]，使用tab缩进
+	PHPStyleShort = "short"
+	// PHPStyleVarExport 与PHP var_export的输出相同，array ( 'key' => 'value', )，使用两个空格缩进
+	PHPStyleVarExport = "varExport"
 )
 
-var deep = 0
+var (
+	phpIntRegexp   = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+	phpFloatRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)\.[0-9]+([eE][-+]?[0-9]+)?$`)
+)
+
+// PHPEncoder 将Go数据转换为PHP代码，缩进在每次调用时传递，可以在多个goroutine中同时使用
+type PHPEncoder struct {
+	// Style PHPStyleShort（默认）或PHPStyleVarExport
+	Style string
+	// Typed 为true时字符串值按内容推断为int、float、bool、null，有前导0的数字仍为字符串
+	Typed bool
+	// StrictTypes 为true时文件开头加上 declare(strict_types=1);
+	StrictTypes bool
+}
 
 // GoTypeToPHPCode 将Go简单数据类型转换为PHP数组
 func GoTypeToPHPCode(v interface{}) string {
-	t := reflect.TypeOf(v)
-	value := reflect.ValueOf(v)
-	switch t.Kind() {
+	return PHPEncoder{}.Encode(v)
+}
+
+// File 输出返回v的PHP文件内容
+func (e PHPEncoder) File(v interface{}) string {
+	header := "<?php\n\n"
+	if e.StrictTypes {
+		header += "declare(strict_types=1);\n\n"
+	}
+	return header + "return " + e.Encode(v) + ";\n"
+}
+
+// Encode 将v转换为PHP表达式
+func (e PHPEncoder) Encode(v interface{}) string {
+	var b strings.Builder
+	e.encode(&b, reflect.ValueOf(v), 0)
+	return b.String()
+}
+
+func (e PHPEncoder) indent(depth int) string {
+	if e.Style == PHPStyleVarExport {
+		return strings.Repeat("  ", depth)
+	}
+	return strings.Repeat("\t", depth)
+}
+
+func (e PHPEncoder) encode(b *strings.Builder, v reflect.Value, depth int) {
+	switch v = indirect(v); v.Kind() {
+	case reflect.Invalid, reflect.Interface, reflect.Ptr:
+		b.WriteString(e.null())
 	case reflect.Map:
-		return isMap(value)
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return phpKeyString(keys[i]) < phpKeyString(keys[j]) })
+		e.array(b, depth, len(keys), func(i int) (string, reflect.Value) {
+			return phpString(phpKeyString(keys[i])), v.MapIndex(keys[i])
+		})
 	case reflect.Array, reflect.Slice:
-		return isSlice(value)
+		e.array(b, depth, v.Len(), func(i int) (string, reflect.Value) {
+			if e.Style == PHPStyleVarExport {
+				return strconv.Itoa(i), v.Index(i)
+			}
+			return "", v.Index(i)
+		})
 	case reflect.Struct:
-		return isStruct(t, value)
+		t := v.Type()
+		e.array(b, depth, v.NumField(), func(i int) (string, reflect.Value) {
+			return phpString(t.Field(i).Tag.Get("php")), v.Field(i)
+		})
 	case reflect.String:
-		return isString(value)
+		b.WriteString(e.scalar(v.String()))
+	case reflect.Bool:
+		b.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		b.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		b.WriteString(phpFloat(v.Float()))
 	default:
-		return isOther(value)
+		b.WriteString(e.null())
+	}
+}
+
+// array item返回第i个元素的key（已转换为PHP表达式，为空时不输出key）及值
+func (e PHPEncoder) array(b *strings.Builder, depth, n int, item func(i int) (string, reflect.Value)) {
+	if e.Style == PHPStyleVarExport {
+		b.WriteString("array (\n")
+	} else {
+		b.WriteString("[\n")
+	}
+	for i := 0; i < n; i++ {
+		key, value := item(i)
+		b.WriteString(e.indent(depth + 1))
+		if key != "" {
+			b.WriteString(key + " => ")
+		}
+		if e.Style == PHPStyleVarExport && isPHPArray(value) {
+			// var_export的数组值从下一行开始
+			b.WriteString("\n" + e.indent(depth+1))
+		}
+		e.encode(b, value, depth+1)
+		b.WriteString(",\n")
+	}
+	b.WriteString(e.indent(depth))
+	if e.Style == PHPStyleVarExport {
+		b.WriteString(")")
+	} else {
+		b.WriteString("]")
 	}
 }
 
-func tab() (str string) {
-	str = ""
-	for i := 0; i < deep; i++ {
-		str += "\t"
+func (e PHPEncoder) null() string {
+	if e.Style == PHPStyleVarExport {
+		return "NULL"
 	}
-	return
+	return "null"
 }
 
-func isMap(v reflect.Value) string {
-	deep++
-	str := arrayPrefix + "\n"
-	keys := v.MapKeys()
-	sortKeys := make([]string, 0, len(keys))
-	for _, key := range keys {
-		sortKeys = append(sortKeys, key.Interface().(string))
+// scalar Typed时按内容推断类型，否则写为字符串
+func (e PHPEncoder) scalar(s string) string {
+	if !e.Typed {
+		return phpString(s)
 	}
-	sort.Strings(sortKeys)
-	for _, k := range sortKeys {
-		key := reflect.ValueOf(k)
-		value := v.MapIndex(key)
-		str += fmt.Sprintf("%s%v %s %v%s\n",
-			tab(), GoTypeToPHPCode(key.Interface()), arrayLink, GoTypeToPHPCode(value.Interface()), arrayEnding)
+	switch {
+	case s == "true" || s == "false":
+		return s
+	case s == "null":
+		return e.null()
+	case phpIntRegexp.MatchString(s):
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return s
+		}
+	case phpFloatRegexp.MatchString(s):
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) {
+			return s
+		}
 	}
-	deep--
-	str += tab() + arraySuffix
-	return str
+	return phpString(s)
 }
 
-func isSlice(v reflect.Value) string {
-	deep++
-	str := arrayPrefix + "\n"
-	for i := 0; i < v.Len(); i++ {
-		str += fmt.Sprintf("%s%v%s\n",
-			tab(), GoTypeToPHPCode(v.Index(i).Interface()), arrayEnding)
+// indirect 取interface、指针指向的值，nil时返回nil的interface、指针
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
 	}
-	deep--
-	str += tab() + arraySuffix
-	return str
+	return v
 }
 
-func isStruct(t reflect.Type, v reflect.Value) string {
-	deep++
-	str := arrayPrefix + "\n"
-	for i := 0; i < v.NumField(); i++ {
-		key := t.Field(i).Tag.Get("php")
-		str += fmt.Sprintf("%s'%s' %s %v%s\n",
-			tab(), key, arrayLink, GoTypeToPHPCode(v.Field(i).Interface()), arrayEnding)
+func isPHPArray(v reflect.Value) bool {
+	switch indirect(v).Kind() {
+	case reflect.Map, reflect.Array, reflect.Slice, reflect.Struct:
+		return true
+	default:
+		return false
 	}
-	deep--
-	str += tab() + arraySuffix
-	return str
 }
 
-func isString(v reflect.Value) string {
-	return fmt.Sprintf("'%s'", strings.Replace(v.String(), "'", "\\'", -1))
+func phpKeyString(v reflect.Value) string {
+	return fmt.Sprint(indirect(v).Interface())
+}
+
+// phpString 单引号字符串中只有 \ 和 ' 需要转义
+func phpString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
 }
 
-func isOther(v reflect.Value) string {
-	return fmt.Sprintf("%v", v.Interface())
+// phpFloat 整数值的float加上 .0，避免被PHP当作int
+func phpFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NAN"
+	case math.IsInf(f, 1):
+		return "INF"
+	case math.IsInf(f, -1):
+		return "-INF"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
 }
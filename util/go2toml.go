@@ -0,0 +1,154 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var tomlBareKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// GoTypeToTOML 将json、yaml解析后的结构转换为TOML：key按升序输出，先输出当前表的值再输出子表，
+// 数组中的对象写为内联表，null写为空字符串
+func GoTypeToTOML(tree map[string]interface{}) string {
+	var b strings.Builder
+	writeTOMLTable(&b, nil, tree)
+	return b.String()
+}
+
+func writeTOMLTable(b *strings.Builder, path []string, table map[string]interface{}) {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tables := make([]string, 0)
+	for _, key := range keys {
+		if _, ok := table[key].(map[string]interface{}); ok {
+			tables = append(tables, key)
+			continue
+		}
+		b.WriteString(tomlKey(key) + " = " + tomlValue(table[key]) + "\n")
+	}
+	for _, key := range tables {
+		sub := append(append([]string{}, path...), tomlKey(key))
+		child := table[key].(map[string]interface{})
+		// 只有子表的表不需要单独输出表头
+		if hasTOMLValue(child) || len(child) == 0 {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString("[" + strings.Join(sub, ".") + "]\n")
+		}
+		writeTOMLTable(b, sub, child)
+	}
+}
+
+func hasTOMLValue(table map[string]interface{}) bool {
+	for _, value := range table {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func tomlKey(key string) string {
+	if tomlBareKeyRegexp.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+func tomlValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return `""`
+	case string:
+		return tomlString(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case uint64:
+		return strconv.FormatUint(value, 10)
+	case float64:
+		return tomlFloat(value)
+	case json.Number:
+		return value.String()
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, tomlValue(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(value))
+		for _, key := range keys {
+			items = append(items, tomlKey(key)+" = "+tomlValue(value[key]))
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	default:
+		return tomlString(fmt.Sprint(value))
+	}
+}
+
+func tomlFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEn") {
+		s += ".0"
+	}
+	return s
+}
+
+// tomlString 基本字符串，转义 \ " 及控制字符
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				b.WriteString(fmt.Sprintf(`\u%04X`, r))
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
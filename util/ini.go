@@ -0,0 +1,125 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// iniNumberRegexp 数字不加引号，parse_ini_file以INI_SCANNER_TYPED读取时为int、float
+	iniNumberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+	// iniKeyInvalidChars parse_ini_file的key中不能出现的字符
+	iniKeyInvalidChars = "=;&|^$~(){}!\"[]?'# \t\r\n"
+	// iniReservedWords 不能作为key，作为值时不加引号会被转换为 "1" 或 ""
+	iniReservedWords = map[string]bool{
+		"null": true, "yes": true, "no": true, "true": true, "false": true, "on": true, "off": true, "none": true,
+	}
+)
+
+// iniSection 一个ini区块，name为空时为第一个区块之前的全局配置
+type iniSection struct {
+	name string
+	data map[string]string
+}
+
+// strictINI 按PHP parse_ini_file的语法输出ini：数字以外的值都使用双引号，转义 \ " $，key不合法时返回错误；
+// nested时key按分隔符拆分，最后一段作为key，前面的部分拼接到区块名称后作为子区块，如 [application.db.master] 下的 host。
+// 区块按namespace的顺序输出，子区块按名称排序跟在所属namespace的区块之后；不同namespace得到同名区块时返回错误
+func strictINI(sections []iniSection, opts RenderOptions) (string, error) {
+	separator := opts.Separator
+	if separator == "" {
+		separator = DefaultNestSeparator
+	}
+	expanded := make(map[string]map[string]string)
+	owners := make(map[string]string)
+	names := make([]string, 0)
+	for _, section := range sections {
+		subs := make([]string, 0)
+		add := func(name string) error {
+			if owner, ok := owners[name]; ok {
+				if owner != section.name {
+					return fmt.Errorf("section [%s] of %q conflicts with section of %q", name, section.name, owner)
+				}
+				return nil
+			}
+			owners[name] = section.name
+			expanded[name] = make(map[string]string)
+			if name != section.name {
+				subs = append(subs, name)
+			}
+			return nil
+		}
+		// 没有配置的namespace也写出区块，与非严格模式一致
+		if err := add(section.name); err != nil {
+			return "", err
+		}
+		for key, value := range section.data {
+			name := section.name
+			if i := strings.LastIndex(key, separator); opts.Nested && i > 0 && i+len(separator) < len(key) {
+				name = joinSection(name, key[:i], separator)
+				key = key[i+len(separator):]
+			}
+			if err := checkINIKey(key); err != nil {
+				return "", err
+			}
+			if err := add(name); err != nil {
+				return "", err
+			}
+			expanded[name][key] = value
+		}
+		sort.Strings(subs)
+		names = append(names, section.name)
+		names = append(names, subs...)
+	}
+	// 全局配置必须写在第一个区块之前
+	if _, ok := expanded[""]; ok {
+		ordered := []string{""}
+		for _, name := range names {
+			if name != "" {
+				ordered = append(ordered, name)
+			}
+		}
+		names = ordered
+	}
+
+	content := make([]string, 0)
+	for _, name := range names {
+		if name != "" {
+			if strings.ContainsAny(name, "[]\"\r\n") {
+				return "", fmt.Errorf("section %q cannot be written to ini", name)
+			}
+			content = append(content, "["+name+"]")
+		} else if len(expanded[name]) == 0 {
+			continue
+		}
+		for _, key := range sortedKeys(expanded[name]) {
+			content = append(content, key+"="+iniValue(expanded[name][key]))
+		}
+		content = append(content, "")
+	}
+	return strings.Join(content, "\n"), nil
+}
+
+func joinSection(section, sub, separator string) string {
+	if section == "" {
+		return sub
+	}
+	return section + separator + sub
+}
+
+func checkINIKey(key string) error {
+	if key == "" || strings.ContainsAny(key, iniKeyInvalidChars) || iniReservedWords[strings.ToLower(key)] {
+		return fmt.Errorf("key %q cannot be written to strict ini", key)
+	}
+	return nil
+}
+
+// iniValue 双引号中 \ " $ 需要转义，换行原样保留
+func iniValue(value string) string {
+	if iniNumberRegexp.MatchString(value) {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(value) + `"`
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrictINIValue(t *testing.T) {
+	cases := []struct {
+		value, want string
+	}{
+		{"0", `0`},
+		{"-12", `-12`},
+		{"3.14", `3.14`},
+		{"01", `"01"`},
+		{"1e3", `"1e3"`},
+		{"", `""`},
+		{"127.0.0.1", `"127.0.0.1"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\path`, `"C:\\path"`},
+		{"$HOME ${USER}", `"\$HOME \${USER}"`},
+		{"a;b # c", `"a;b # c"`},
+		{"line1\nline2", "\"line1\nline2\""},
+		{"null", `"null"`},
+		{"yes", `"yes"`},
+		{"no", `"no"`},
+		{"true", `"true"`},
+		{"false", `"false"`},
+		{"none", `"none"`},
+		{"On", `"On"`},
+	}
+	for _, c := range cases {
+		got, err := strictINI([]iniSection{{data: map[string]string{"key": c.value}}}, RenderOptions{})
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", c.value, err)
+			continue
+		}
+		if want := "key=" + c.want + "\n"; got != want {
+			t.Errorf("%q: got %q, want %q", c.value, got, want)
+		}
+	}
+}
+
+func TestStrictINIInvalidKey(t *testing.T) {
+	keys := []string{
+		"", "null", "NULL", "yes", "no", "true", "False", "none", "on", "off",
+		"a=b", "a;b", "a&b", "a|b", "a^b", "a$b", "a~b", "a(b)", "a{b}", "a!b",
+		`a"b`, "a[b]", "a?b", "a'b", "a#b", "a b", "a\tb", "a\nb",
+	}
+	for _, key := range keys {
+		if got, err := strictINI([]iniSection{{data: map[string]string{key: "x"}}}, RenderOptions{}); err == nil {
+			t.Errorf("key %q should fail, got %q", key, got)
+		}
+	}
+	// nested时只检查拆分后的最后一段
+	opts := RenderOptions{Nested: true}
+	if _, err := strictINI([]iniSection{{name: "app", data: map[string]string{"db.null": "x"}}}, opts); err == nil {
+		t.Errorf("nested key db.null should fail")
+	}
+	if _, err := strictINI([]iniSection{{name: "app", data: map[string]string{"null.host": "x"}}}, opts); err != nil {
+		t.Errorf("nested key null.host should be written as [app.null] host, got %v", err)
+	}
+}
+
+func TestStrictINISections(t *testing.T) {
+	cases := []struct {
+		name     string
+		sections []iniSection
+		nested   bool
+		want     []string
+	}{
+		{
+			name: "namespace order",
+			sections: []iniSection{
+				{name: "zeta", data: map[string]string{"b": "2", "a": "1"}},
+				{name: "alpha", data: map[string]string{}},
+				{name: "mid", data: map[string]string{"k": "v"}},
+			},
+			want: []string{"[zeta]", "a=1", "b=2", "", "[alpha]", "", "[mid]", `k="v"`, ""},
+		},
+		{
+			name: "nested sub sections follow their namespace",
+			sections: []iniSection{
+				{name: "web", data: map[string]string{"port": "80", "db.slave.host": "s", "db.master.host": "m", "cache.ttl": "60"}},
+				{name: "app", data: map[string]string{"db.host": "h"}},
+			},
+			nested: true,
+			want: []string{
+				"[web]", "port=80", "", "[web.cache]", "ttl=60", "", "[web.db.master]", `host="m"`, "",
+				"[web.db.slave]", `host="s"`, "", "[app]", "", "[app.db]", `host="h"`, "",
+			},
+		},
+		{
+			name:     "global keys before sections",
+			sections: []iniSection{{data: map[string]string{"name": "x", "db.host": "h"}}},
+			nested:   true,
+			want:     []string{`name="x"`, "", "[db]", `host="h"`, ""},
+		},
+	}
+	for _, c := range cases {
+		got, err := strictINI(c.sections, RenderOptions{Nested: c.nested})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if want := strings.Join(c.want, "\n"); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, got, want)
+		}
+	}
+}
+
+func TestStrictINISectionConflict(t *testing.T) {
+	cases := [][]iniSection{
+		{
+			{name: "application", data: map[string]string{"db.host": "h"}},
+			{name: "application.db", data: map[string]string{"port": "3306"}},
+		},
+		{
+			{name: "application.db", data: map[string]string{"port": "3306"}},
+			{name: "application", data: map[string]string{"db.host": "h"}},
+		},
+		{
+			{name: "a", data: map[string]string{"b.c.key": "1"}},
+			{name: "a.b", data: map[string]string{"c.key": "2"}},
+		},
+	}
+	for _, sections := range cases {
+		if got, err := strictINI(sections, RenderOptions{Nested: true}); err == nil {
+			t.Errorf("%s and %s should conflict, got\n%s", sections[0].name, sections[1].name, got)
+		}
+	}
+}
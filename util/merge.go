@@ -0,0 +1,162 @@
+package util
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultXMLRoot = "config"
+
+var xmlNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// ValidXMLName 是否为合法的xml元素名称
+func ValidXMLName(name string) bool {
+	return xmlNameRegexp.MatchString(name) && !strings.HasPrefix(strings.ToLower(name), "xml")
+}
+
+// CanMerge namespace能否合并写入syntax格式的文件：json、yaml、toml只能合并properties及json、yaml格式的namespace，
+// xml只能合并xml格式的namespace，其他格式不限制
+func CanMerge(syntax, namespace string) bool {
+	name := ParseNSName(namespace)
+	switch strings.ToLower(syntax) {
+	case F_JSON, F_YAML, F_YML, F_TOML:
+		return name.IsProperties() || name.IsStructured()
+	case F_XML:
+		return name.Format == NS_XML
+	default:
+		return true
+	}
+}
+
+// KeyedByBase 合并写入syntax格式的文件时是否以namespace去掉格式后缀的名称作为区块名或key：ini的区块、php、json、yaml、toml的分组，
+// flat时php、json、yaml、toml不分组；dotEnv、properties只写为注释，txt、xml、template不使用该名称
+func KeyedByBase(syntax string, flat bool) bool {
+	switch strings.ToLower(syntax) {
+	case F_INI:
+		return true
+	case F_PHP, F_JSON, F_YAML, F_YML, F_TOML:
+		return !flat
+	default:
+		return false
+	}
+}
+
+// ParseStructured 解析json、yaml格式的内容，顶层必须是对象，yaml中非字符串的key转换为字符串，
+// json中的整数保持整数，大整数保留原始的数字
+func ParseStructured(format, content string) (map[string]interface{}, error) {
+	var tree interface{}
+	switch format {
+	case NS_JSON:
+		decoder := json.NewDecoder(strings.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&tree); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parse json content error, %s", err.Error())
+		}
+	case NS_YAML, NS_YML:
+		if err := yaml.Unmarshal([]byte(content), &tree); err != nil {
+			return nil, fmt.Errorf("parse yaml content error, %s", err.Error())
+		}
+	default:
+		return nil, fmt.Errorf("%s content cannot be parsed", format)
+	}
+	switch normalized := normalizeTree(tree).(type) {
+	case map[string]interface{}:
+		return normalized, nil
+	case nil:
+		return map[string]interface{}{}, nil
+	default:
+		return nil, fmt.Errorf("%s content is not an object", format)
+	}
+}
+
+func normalizeTree(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		tree := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			tree[fmt.Sprint(key)] = normalizeTree(child)
+		}
+		return tree
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = normalizeTree(child)
+		}
+		return value
+	case []interface{}:
+		for i, child := range value {
+			value[i] = normalizeTree(child)
+		}
+		return value
+	case json.Number:
+		return normalizeNumber(value)
+	default:
+		return value
+	}
+}
+
+// normalizeNumber json中的整数转换为int64或uint64，超出范围时保留原始的json.Number，避免转换为float64丢失精度；
+// 只有包含小数点或指数的数字才转换为float64
+func normalizeNumber(n json.Number) interface{} {
+	literal := n.String()
+	if !strings.ContainsAny(literal, ".eE") {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(literal, 10, 64); err == nil {
+			return u
+		}
+		return n
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n
+}
+
+// MergeTree 将src深度合并到dst：两边都是对象时递归合并，否则src的值覆盖dst
+func MergeTree(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcChild, srcIsMap := value.(map[string]interface{})
+		dstChild, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			MergeTree(dstChild, srcChild)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// xmlFragment 去掉xml声明，内容不是合法的xml或包含DOCTYPE等声明时返回错误
+func xmlFragment(content string) (string, error) {
+	fragment := strings.TrimSpace(content)
+	if strings.HasPrefix(fragment, "<?xml") {
+		if end := strings.Index(fragment, "?>"); end >= 0 {
+			fragment = strings.TrimSpace(fragment[end+2:])
+		}
+	}
+	decoder := xml.NewDecoder(strings.NewReader("<_>" + fragment + "</_>"))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return fragment, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("parse xml content error, %s", err.Error())
+		}
+		if directive, ok := token.(xml.Directive); ok {
+			return "", fmt.Errorf("xml content with <!%s> cannot be merged", strings.Fields(string(directive))[0])
+		}
+	}
+}
+
+// wrapXML 将多个xml片段放到root元素下
+func wrapXML(root string, fragments []string) string {
+	return xml.Header + "<" + root + ">\n" + strings.Join(fragments, "\n") + "\n</" + root + ">\n"
+}
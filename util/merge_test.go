@@ -0,0 +1,78 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeBigNumbers(t *testing.T) {
+	content := `{"big": 12345678901234567890, "huge": 123456789012345678901234567890, "neg": -9223372036854775808,
+		"float": 1.5, "exp": 1e3, "list": [12345678901234567891]}`
+	nss := []string{"app.json"}
+	multiData := map[string]map[string]string{"app.json": {"content": content}}
+	cases := []struct {
+		syntax string
+		want   []string
+	}{
+		{F_JSON, []string{
+			`"big": 12345678901234567890`, `"huge": 123456789012345678901234567890`, `"neg": -9223372036854775808`,
+			`"float": 1.5`, `"exp": 1000`, `12345678901234567891`,
+		}},
+		{F_YAML, []string{
+			"big: 12345678901234567890\n", "huge: 123456789012345678901234567890\n", "neg: -9223372036854775808\n",
+			"float: 1.5\n", "exp: 1000\n", "- 12345678901234567891\n",
+		}},
+		{F_TOML, []string{
+			"big = 12345678901234567890\n", "huge = 123456789012345678901234567890\n",
+			"neg = -9223372036854775808\n", "float = 1.5\n", "exp = 1000.0\n", "list = [12345678901234567891]\n",
+		}},
+	}
+	for _, c := range cases {
+		content, err := renderMultiNS(c.syntax, nss, multiData, RenderOptions{Flat: true})
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", c.syntax, err)
+		}
+		for _, want := range c.want {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s: %q not found in\n%s", c.syntax, want, content)
+			}
+		}
+	}
+}
+
+func TestMultiNSSingleRaw(t *testing.T) {
+	yamlContent := "# db config\nbase: &base\n  host: m1\nslave:\n  <<: *base\n  port: 3306\n"
+	xmlContent := "<?xml version=\"1.0\"?>\n<beans><bean id=\"a\"/></beans>\n"
+	multiData := map[string]map[string]string{
+		"mysql.yaml": {"content": yamlContent},
+		"extra.yml":  {"content": "z: 1\na: 2\n"},
+		"a.xml":      {"content": xmlContent},
+		"b.xml":      {"content": "<b/>"},
+	}
+	cases := []struct {
+		syntax string
+		nss    []string
+		opts   RenderOptions
+		want   string
+	}{
+		{F_YAML, []string{"mysql.yaml"}, RenderOptions{}, yamlContent},
+		{F_YML, []string{"mysql.yaml"}, RenderOptions{}, yamlContent},
+		{F_XML, []string{"a.xml"}, RenderOptions{}, xmlContent},
+		// 多个namespace或展平为key/value时合并写入
+		{F_YAML, []string{"mysql.yaml", "extra.yml"}, RenderOptions{},
+			"a: 2\nbase:\n  host: m1\nslave:\n  host: m1\n  port: 3306\nz: 1\n"},
+		{F_XML, []string{"a.xml", "b.xml"}, RenderOptions{},
+			"<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<config>\n<beans><bean id=\"a\"/></beans>\n<b/>\n</config>\n"},
+		{F_YAML, []string{"extra.yml"}, RenderOptions{Flat: true, FlattenSeparator: "_"}, "a: \"2\"\nz: \"1\"\n"},
+	}
+	for _, c := range cases {
+		got, err := renderMultiNS(c.syntax, c.nss, multiData, c.opts)
+		if err != nil {
+			t.Errorf("%s %v: unexpected error %v", c.syntax, c.nss, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s %v: got\n%q\nwant\n%q", c.syntax, c.nss, got, c.want)
+		}
+	}
+}
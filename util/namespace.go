@@ -0,0 +1,59 @@
+package util
+
+import (
+	"strings"
+)
+
+// Apollo支持的namespace格式，properties格式的namespace名称可以省略后缀
+const (
+	NS_PROPERTIES = "properties"
+	NS_XML        = "xml"
+	NS_JSON       = "json"
+	NS_YAML       = "yaml"
+	NS_YML        = "yml"
+	NS_TXT        = "txt"
+)
+
+// NSName namespace名称，Base为去掉格式后缀后的名称，Format为namespace的格式。
+// 只有Apollo支持的格式才被当作后缀，公共namespace如 TEST1.redis 的Base为 TEST1.redis、Format为properties，
+// infra.mysql.json 的Base为 infra.mysql、Format为json
+type NSName struct {
+	Name   string
+	Base   string
+	Format string
+}
+
+func ParseNSName(namespace string) NSName {
+	name := NSName{Name: namespace, Base: namespace, Format: NS_PROPERTIES}
+	i := strings.LastIndex(namespace, ".")
+	if i <= 0 {
+		return name
+	}
+	switch format := strings.ToLower(namespace[i+1:]); format {
+	case NS_PROPERTIES, NS_XML, NS_JSON, NS_YAML, NS_YML, NS_TXT:
+		name.Base, name.Format = namespace[:i], format
+	}
+	return name
+}
+
+// IsProperties properties格式的namespace为key/value配置，其他格式的配置内容在content中
+func (n NSName) IsProperties() bool {
+	return n.Format == NS_PROPERTIES
+}
+
+// Syntax namespace独立文件默认的文件格式，properties写为.properties，其他格式原样写入content
+func (n NSName) Syntax() string {
+	switch n.Format {
+	case NS_PROPERTIES:
+		return F_PROPERTIES
+	case NS_YAML, NS_YML, NS_XML:
+		return n.Format
+	default:
+		return F_TXT
+	}
+}
+
+// IsStructured json、yaml格式的namespace内容可以解析并展平为key/value
+func (n NSName) IsStructured() bool {
+	return n.Format == NS_JSON || n.Format == NS_YAML || n.Format == NS_YML
+}
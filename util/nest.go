@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// NestConflictFlat 同一个key既有值又有下级key时（如 a=1 与 a.b=2），该key及其下级key都保持原样不展开
+	NestConflictFlat = "flat"
+	// NestConflictError 出现冲突时写入失败
+	NestConflictError = "error"
+
+	DefaultNestSeparator = "."
+)
+
+// NestKeys 按分隔符将key展开为多层map，如 db.master.host 展开为 db => [master => [host => ...]]，
+// 冲突按conflict处理，见NestConflictFlat、NestConflictError
+func NestKeys(data map[string]string, separator, conflict string) (map[string]interface{}, error) {
+	if separator == "" {
+		separator = DefaultNestSeparator
+	}
+	return nestLevel(data, separator, conflict, "")
+}
+
+// nestLevel data的key为相对当前层的路径，prefix为当前层的完整路径，用于冲突时的提示
+func nestLevel(data map[string]string, separator, conflict, prefix string) (map[string]interface{}, error) {
+	groups := make(map[string]map[string]string)
+	for key, value := range data {
+		head, rest := key, ""
+		if i := strings.Index(key, separator); i >= 0 {
+			head, rest = key[:i], key[i+len(separator):]
+		}
+		if _, ok := groups[head]; !ok {
+			groups[head] = make(map[string]string)
+		}
+		if rest == "" && head == key {
+			groups[head][""] = value
+		} else {
+			groups[head][separator+rest] = value
+		}
+	}
+
+	heads := make([]string, 0, len(groups))
+	for head := range groups {
+		heads = append(heads, head)
+	}
+	sort.Strings(heads)
+
+	nested := make(map[string]interface{})
+	for _, head := range heads {
+		group := groups[head]
+		value, isLeaf := group[""]
+		if isLeaf && len(group) == 1 {
+			nested[head] = value
+			continue
+		}
+		if isLeaf {
+			if conflict == NestConflictError {
+				return nil, fmt.Errorf("key %q has a value and also nested keys, cannot expand it with separator %q",
+					prefix+head, separator)
+			}
+			for suffix, value := range group {
+				nested[head+suffix] = value
+			}
+			continue
+		}
+		children := make(map[string]string, len(group))
+		for suffix, value := range group {
+			children[suffix[len(separator):]] = value
+		}
+		child, err := nestLevel(children, separator, conflict, prefix+head+separator)
+		if err != nil {
+			return nil, err
+		}
+		nested[head] = child
+	}
+	return nested, nil
+}
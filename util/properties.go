@@ -0,0 +1,168 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// ParseProperties 解析Java的.properties内容：支持#、!注释，=、:或空白分隔key和value，
+// 行尾反斜杠续行，以及\t、\n、\uXXXX等转义
+func ParseProperties(content string) map[string]string {
+	props := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continued(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		if continued(line) {
+			line = line[:len(line)-1]
+		}
+		key, value := splitProperty(line)
+		props[unescapeProperty(key)] = unescapeProperty(value)
+	}
+	return props
+}
+
+// continued 行尾有奇数个反斜杠时续行
+func continued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func splitProperty(line string) (string, string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '=', ':':
+			return line[:i], strings.TrimLeft(line[i+1:], " \t\f")
+		case ' ', '\t', '\f':
+			value := strings.TrimLeft(line[i:], " \t\f")
+			if value != "" && (value[0] == '=' || value[0] == ':') {
+				value = strings.TrimLeft(value[1:], " \t\f")
+			}
+			return line[:i], value
+		}
+	}
+	return line, ""
+}
+
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					i += 4
+					// 补充平面的字符写为两个\uXXXX（UTF-16代理对）
+					if utf16.IsSurrogate(rune(r)) && i+6 < len(s) && s[i+1:i+3] == `\u` {
+						if low, err := strconv.ParseUint(s[i+3:i+7], 16, 32); err == nil {
+							if pair := utf16.DecodeRune(rune(r), rune(low)); pair != unicode.ReplacementChar {
+								b.WriteRune(pair)
+								i += 6
+								continue
+							}
+						}
+					}
+					b.WriteRune(rune(r))
+					continue
+				}
+			}
+			b.WriteByte('u')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// MarshalProperties 按Java Properties.store的规则输出.properties内容，key按升序排列：
+// key中的空白及 = : # ! 需要转义，值只转义开头的空格，非ASCII字符写为\uXXXX；值中的换行写为 \n 并续行，便于阅读
+func MarshalProperties(data map[string]string) string {
+	lines := make([]string, 0, len(data))
+	for _, key := range sortedKeys(data) {
+		lines = append(lines, escapeProperty(key, true)+"="+propertyValue(data[key]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// propertyValue 按换行拆分后分别转义，续行的开头空白在读取时会被忽略，因此续行开头的空格也需要转义；
+// 以换行结尾时不再续行
+func propertyValue(value string) string {
+	parts := strings.Split(value, "\n")
+	var b strings.Builder
+	for i, part := range parts {
+		b.WriteString(escapeProperty(part, false))
+		if i == len(parts)-1 {
+			break
+		}
+		b.WriteString(`\n`)
+		if i < len(parts)-2 || parts[i+1] != "" {
+			b.WriteString("\\\n    ")
+		}
+	}
+	return b.String()
+}
+
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			if r < 0x20 || r > 0x7e {
+				for _, unit := range utf16.Encode([]rune{r}) {
+					b.WriteString(fmt.Sprintf(`\u%04X`, unit))
+				}
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
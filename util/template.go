@@ -0,0 +1,118 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// TemplateMeta 模板中可以使用的应用信息
+type TemplateMeta struct {
+	AppId   string
+	Cluster string
+	// ReleaseKeys 各namespace当前配置的releaseKey
+	ReleaseKeys map[string]string
+}
+
+// TemplateData 执行模板时的数据（模板中的 .）
+type TemplateData struct {
+	TemplateMeta
+	// Namespace 写入独立文件时为当前namespace，合并写入时为空
+	Namespace string
+	// Namespaces 写入的namespace，按配置的顺序
+	Namespaces []string
+	// Data 按namespace名称（与配置中的相同）索引的配置，json、yaml等格式的namespace内容在content中
+	Data map[string]map[string]string
+	// Config 独立文件时为当前namespace的配置，合并写入时为所有namespace的配置，同名key以后面的namespace为准
+	Config map[string]string
+}
+
+var templateFuncs = template.FuncMap{
+	"get":          templateGet,
+	"env":          templateEnv,
+	"toJson":       templateToJSON,
+	"quote":        strconv.Quote,
+	"base64decode": templateBase64Decode,
+	"keys":         templateKeys,
+	"has":          templateHas,
+}
+
+// ParseTemplate 解析Go text/template模板，可以使用get、env、toJson、quote、base64decode、keys、has函数
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(text)
+}
+
+// ExecuteTemplate 执行模板，模板出错（如base64decode的内容不合法）时返回错误
+func ExecuteTemplate(tmpl *template.Template, data TemplateData) (string, error) {
+	if tmpl == nil {
+		return "", fmt.Errorf("template is not set")
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// templateGet {{ get .Config "db.host" "127.0.0.1" }}，key不存在时返回默认值（未指定时为空字符串）
+func templateGet(data map[string]string, key string, def ...string) string {
+	if value, ok := data[key]; ok {
+		return value
+	}
+	return strings.Join(def, "")
+}
+
+// templateEnv {{ env "HOSTNAME" "localhost" }}，环境变量未设置时返回默认值
+func templateEnv(name string, def ...string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return strings.Join(def, "")
+}
+
+func templateToJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func templateBase64Decode(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("base64decode error, %s", err.Error())
+	}
+	return string(decoded), nil
+}
+
+// templateKeys 按升序返回map的key，用于 {{ range keys .Config }}
+func templateKeys(m interface{}) ([]string, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("keys of %T is not supported", m)
+	}
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func templateHas(m interface{}, key string) (bool, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return false, fmt.Errorf("has of %T is not supported", m)
+	}
+	return v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).IsValid(), nil
+}
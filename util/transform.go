@@ -0,0 +1,167 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	TransformCaseUpper = "upper"
+	TransformCaseLower = "lower"
+)
+
+// TransformRules 写入前对namespace的key/value过滤及改名的规则，按以下顺序执行：
+// include、exclude按原key过滤 → rename中的key直接改为指定的名称，不再执行后面的规则 →
+// stripPrefix → replace替换分隔符 → case转换大小写 → addPrefix
+type TransformRules struct {
+	// Include 不为空时只保留匹配的key，Exclude 去掉匹配的key；
+	// 写为 /.../ 时为正则表达式，否则为glob，* 匹配任意字符（包括 .），? 匹配一个字符
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+	// StripPrefix 去掉key的前缀，没有该前缀的key保持不变
+	StripPrefix string `yaml:"stripPrefix,omitempty"`
+	// Replace 替换key中的字符串，如 {".": "_"}，较长的先匹配
+	Replace map[string]string `yaml:"replace,omitempty"`
+	// Case 转换为大写（upper）或小写（lower）
+	Case string `yaml:"case,omitempty"`
+	// AddPrefix 给key加上前缀
+	AddPrefix string `yaml:"addPrefix,omitempty"`
+	// Rename 原key到新名称的映射
+	Rename map[string]string `yaml:"rename,omitempty"`
+}
+
+// KeyTransform 编译后的TransformRules，可以在多个goroutine中同时使用
+type KeyTransform struct {
+	rules    TransformRules
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	replacer *strings.Replacer
+}
+
+// NewKeyTransform 编译过滤规则，规则不合法（如正则表达式错误、不支持的case）时返回错误
+func NewKeyTransform(rules TransformRules) (*KeyTransform, error) {
+	switch rules.Case {
+	case "", TransformCaseUpper, TransformCaseLower:
+	default:
+		return nil, fmt.Errorf("case %q is not supported, use %s or %s", rules.Case, TransformCaseUpper, TransformCaseLower)
+	}
+	t := &KeyTransform{rules: rules}
+	var err error
+	if t.include, err = compileKeyPatterns(rules.Include); err != nil {
+		return nil, fmt.Errorf("include %s", err.Error())
+	}
+	if t.exclude, err = compileKeyPatterns(rules.Exclude); err != nil {
+		return nil, fmt.Errorf("exclude %s", err.Error())
+	}
+	if len(rules.Replace) > 0 {
+		olds := make([]string, 0, len(rules.Replace))
+		for old := range rules.Replace {
+			if old == "" {
+				return nil, fmt.Errorf("replace of empty string is not supported")
+			}
+			olds = append(olds, old)
+		}
+		// strings.Replacer在同一位置按参数顺序匹配，较长的放在前面
+		sort.Slice(olds, func(i, j int) bool {
+			if len(olds[i]) != len(olds[j]) {
+				return len(olds[i]) > len(olds[j])
+			}
+			return olds[i] < olds[j]
+		})
+		pairs := make([]string, 0, 2*len(olds))
+		for _, old := range olds {
+			pairs = append(pairs, old, rules.Replace[old])
+		}
+		t.replacer = strings.NewReplacer(pairs...)
+	}
+	return t, nil
+}
+
+func compileKeyPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := globToRegexp(pattern)
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q error, %s", pattern, err.Error())
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// Apply 返回过滤、改名后的key/value，不修改data；不同的key转换后重名时返回错误
+func (t *KeyTransform) Apply(data map[string]string) (map[string]string, error) {
+	result := make(map[string]string, len(data))
+	from := make(map[string]string, len(data))
+	for _, key := range sortedKeys(data) {
+		if !t.match(key) {
+			continue
+		}
+		name := t.rename(key)
+		if name == "" {
+			return nil, fmt.Errorf("key %s is renamed to empty", key)
+		}
+		if first, ok := from[name]; ok {
+			return nil, fmt.Errorf("keys %s and %s are both renamed to %s", first, key, name)
+		}
+		from[name] = key
+		result[name] = data[key]
+	}
+	return result, nil
+}
+
+func (t *KeyTransform) match(key string) bool {
+	if len(t.include) > 0 && !matchAny(t.include, key) {
+		return false
+	}
+	return !matchAny(t.exclude, key)
+}
+
+func matchAny(patterns []*regexp.Regexp, key string) bool {
+	for _, re := range patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *KeyTransform) rename(key string) string {
+	if name, ok := t.rules.Rename[key]; ok {
+		return name
+	}
+	key = strings.TrimPrefix(key, t.rules.StripPrefix)
+	if t.replacer != nil {
+		key = t.replacer.Replace(key)
+	}
+	switch t.rules.Case {
+	case TransformCaseUpper:
+		key = strings.ToUpper(key)
+	case TransformCaseLower:
+		key = strings.ToLower(key)
+	}
+	return t.rules.AddPrefix + key
+}